@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/fiunchinho/dmz-controller/writer"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// AdmissionReview is the document exchanged with the API server to validate objects.
+// It's compatible with both the admission.k8s.io/v1 and admission.k8s.io/v1beta1 APIs.
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest contains the object to validate
+type AdmissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      AdmissionKind   `json:"kind"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	// OldObject is the object being replaced, in UPDATE and DELETE operations
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+// AdmissionKind is the kind of the object to validate
+type AdmissionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// AdmissionResponse tells the API server whether the object is allowed
+type AdmissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Result   *AdmissionResult `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// AdmissionResult explains why an object was rejected
+type AdmissionResult struct {
+	Message string `json:"message,omitempty"`
+}
+
+// AdmissionWebhook validates Ingress objects and the dmz-controller ConfigMap before they are stored
+type AdmissionWebhook struct {
+	whitelister   *IngressWhitelister
+	listIngresses func() ([]*repository.IngressObject, error)
+	// warnUnknownProviders allows Ingress objects using unknown providers, returning a warning instead of rejecting them
+	warnUnknownProviders bool
+	// blockConfigMapDeletion rejects deleting the dmz-controller ConfigMap while Ingress objects use its providers.
+	// It defeats the remove ConfigMap deletion policy, which only applies once the ConfigMap is deleted.
+	blockConfigMapDeletion bool
+}
+
+// ServeHTTP answers an AdmissionReview request
+func (webhook *AdmissionWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	review := AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "Expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	response := webhook.review(review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		glog.Errorf("Error writing AdmissionReview response: %s", err.Error())
+	}
+}
+
+// review validates the object of an AdmissionRequest
+func (webhook *AdmissionWebhook) review(request *AdmissionRequest) *AdmissionResponse {
+	switch request.Kind.Kind {
+	case "Ingress":
+		return webhook.reviewIngress(request)
+	case "ConfigMap":
+		return webhook.reviewConfigMap(request)
+	}
+	return allow()
+}
+
+// reviewIngress rejects Ingress objects with malformed CIDRs or using unknown providers.
+// Updates only check the providers when the providers annotation changes, so Ingress objects that were already using
+// unknown providers can still be changed, including by the controller patching their whitelist.
+func (webhook *AdmissionWebhook) reviewIngress(request *AdmissionRequest) *AdmissionResponse {
+	if request.Operation == "DELETE" {
+		return allow()
+	}
+
+	ingress := &repository.IngressObject{}
+	if err := json.Unmarshal(request.Object, ingress); err != nil {
+		return deny(fmt.Sprintf("Error decoding Ingress: %s", err.Error()))
+	}
+	if ingress.Namespace == "" {
+		ingress.Namespace = request.Namespace
+	}
+
+	output := webhook.whitelister.writerFor(ingress)
+	if invalid := writer.InvalidIPs(output, ingress.Annotations); len(invalid) > 0 {
+		return deny(fmt.Sprintf("Invalid CIDRs in the '%s' annotation: %s", output.Annotation(), strings.Join(invalid, ", ")))
+	}
+	if invalid := whitelist.InvalidIPs(ingress.Annotations[ManagedWhitelistAnnotation]); len(invalid) > 0 {
+		return deny(fmt.Sprintf("Invalid CIDRs in the '%s' annotation: %s", ManagedWhitelistAnnotation, strings.Join(invalid, ", ")))
+	}
+
+	annotation, ok := ingress.Annotations[DMZProvidersAnnotation]
+	if !ok {
+		return allow()
+	}
+	if request.Operation == "UPDATE" && len(request.OldObject) > 0 {
+		oldIngress := &repository.IngressObject{}
+		if err := json.Unmarshal(request.OldObject, oldIngress); err != nil {
+			return deny(fmt.Sprintf("Error decoding the previous Ingress: %s", err.Error()))
+		}
+		if oldAnnotation, ok := oldIngress.Annotations[DMZProvidersAnnotation]; ok && oldAnnotation == annotation {
+			return allow()
+		}
+	}
+
+	providers, err := webhook.whitelister.getProviders(ingress.Namespace)
+	if err != nil {
+		// We can't tell whether the providers exist, so we don't block the Ingress object
+		response := allow()
+		response.Warnings = []string{fmt.Sprintf("Providers could not be validated: %s", err.Error())}
+		return response
+	}
+
+	if unknown := getUnknownProviders(annotation, providers); len(unknown) > 0 {
+		message := fmt.Sprintf("Unknown providers in the '%s' annotation: %s", DMZProvidersAnnotation, strings.Join(unknown, ", "))
+		if webhook.warnUnknownProviders {
+			response := allow()
+			response.Warnings = []string{message}
+			return response
+		}
+		return deny(message)
+	}
+
+	return allow()
+}
+
+// reviewConfigMap rejects changes to the dmz-controller ConfigMap that leave Ingress objects using deleted providers.
+// Deleting the ConfigMap is allowed unless blockConfigMapDeletion is set, because the ConfigMap deletion policy decides what happens then.
+func (webhook *AdmissionWebhook) reviewConfigMap(request *AdmissionRequest) *AdmissionResponse {
+	if request.Name != DMZConfigMapName || request.Operation == "CREATE" || webhook.whitelister.configMapRepository == nil {
+		return allow()
+	}
+	if request.Operation == "DELETE" && !webhook.blockConfigMapDeletion {
+		return allow()
+	}
+
+	var configMap *v1.ConfigMap
+	if request.Operation != "DELETE" {
+		configMap = &v1.ConfigMap{}
+		if err := json.Unmarshal(request.Object, configMap); err != nil {
+			return deny(fmt.Sprintf("Error decoding ConfigMap: %s", err.Error()))
+		}
+	}
+
+	ingresses, err := webhook.listIngresses()
+	if err != nil {
+		return deny(fmt.Sprintf("Error listing Ingress objects: %s", err.Error()))
+	}
+
+	// Providers are resolved as if the change had already been applied
+	pendingWhitelister := *webhook.whitelister
+	pendingWhitelister.configMapRepository = &pendingConfigMapRepository{
+		ConfigMapRepository: webhook.whitelister.configMapRepository,
+		namespace:           request.Namespace,
+		configMap:           configMap,
+	}
+
+	broken := []string{}
+	for _, ingress := range ingresses {
+		annotation, ok := ingress.Annotations[DMZProvidersAnnotation]
+		if !ok || (ingress.Namespace != request.Namespace && webhook.whitelister.getConfigNamespace(ingress.Namespace) != request.Namespace) {
+			continue
+		}
+
+		providers, err := pendingWhitelister.getProviders(ingress.Namespace)
+		if err != nil {
+			broken = append(broken, fmt.Sprintf("%s/%s (%s)", ingress.Namespace, ingress.Name, err.Error()))
+			continue
+		}
+
+		// Ingress objects that were already using unknown providers are not blocking the change
+		currentProviders, err := webhook.whitelister.getProviders(ingress.Namespace)
+		if err != nil {
+			continue
+		}
+		alreadyUnknown := make(map[string]bool)
+		for _, name := range getUnknownProviders(annotation, currentProviders) {
+			alreadyUnknown[name] = true
+		}
+
+		deleted := []string{}
+		for _, name := range getUnknownProviders(annotation, providers) {
+			if !alreadyUnknown[name] {
+				deleted = append(deleted, name)
+			}
+		}
+		if len(deleted) > 0 {
+			broken = append(broken, fmt.Sprintf("%s/%s (%s)", ingress.Namespace, ingress.Name, strings.Join(deleted, ", ")))
+		}
+	}
+
+	if len(broken) > 0 {
+		return deny(fmt.Sprintf("These Ingress objects would use deleted providers: %s", strings.Join(broken, "; ")))
+	}
+	return allow()
+}
+
+// pendingConfigMapRepository returns the dmz-controller ConfigMap of a namespace as it will be once a change is applied
+type pendingConfigMapRepository struct {
+	repository.ConfigMapRepository
+	namespace string
+	// configMap is nil when the ConfigMap is being deleted
+	configMap *v1.ConfigMap
+}
+
+// Get retrieves a ConfigMap object by its name
+func (h *pendingConfigMapRepository) Get(namespace string, key string) (*v1.ConfigMap, error) {
+	if namespace != h.namespace || key != DMZConfigMapName {
+		return h.ConfigMapRepository.Get(namespace, key)
+	}
+	if h.configMap == nil {
+		return nil, errors.NewNotFound(v1.Resource("configmaps"), key)
+	}
+	return h.configMap, nil
+}
+
+func allow() *AdmissionResponse {
+	return &AdmissionResponse{Allowed: true}
+}
+
+func deny(message string) *AdmissionResponse {
+	return &AdmissionResponse{
+		Allowed: false,
+		Result:  &AdmissionResult{Message: message},
+	}
+}
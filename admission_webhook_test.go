@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatIngressesUsingKnownProvidersAreAllowed(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+
+	response := SendAdmissionReview(webhook, "Ingress", "CREATE", "team", "", BuildNamespacedIngress("team", "web", "office, !vpn"))
+
+	assert := assert.New(t)
+	assert.True(response.Allowed, "Ingress objects using existing providers must be allowed")
+}
+
+func TestThatIngressesUsingUnknownProvidersAreRejected(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+
+	response := SendAdmissionReview(webhook, "Ingress", "CREATE", "team", "", BuildNamespacedIngress("team", "web", "ofice,corporate"))
+
+	assert := assert.New(t)
+	assert.False(response.Allowed, "Ingress objects using unknown providers must be rejected")
+	assert.Equal("Unknown providers in the 'armesto.net/ingress-providers' annotation: missing, ofice", response.Result.Message)
+}
+
+func TestThatIngressesUsingUnknownProvidersCanBeAllowedWithAWarning(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	webhook.warnUnknownProviders = true
+
+	response := SendAdmissionReview(webhook, "Ingress", "UPDATE", "team", "", BuildNamespacedIngress("team", "web", "ofice"))
+
+	assert := assert.New(t)
+	assert.True(response.Allowed, "Ingress objects using unknown providers must be allowed when warning")
+	assert.Equal([]string{"Unknown providers in the 'armesto.net/ingress-providers' annotation: ofice"}, response.Warnings)
+}
+
+func TestThatUpdatesKeepingTheProvidersAnnotationAreAllowed(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	old := BuildNamespacedIngress("team", "legacy", "removed-long-ago")
+	ingress := BuildNamespacedIngress("team", "legacy", "removed-long-ago")
+	ingress.Annotations[ManagedWhitelistAnnotation] = ""
+
+	kept := SendAdmissionUpdate(webhook, "Ingress", "team", "", old, ingress)
+	changed := SendAdmissionUpdate(webhook, "Ingress", "team", "", old, BuildNamespacedIngress("team", "legacy", "removed-long-ago,ofice"))
+
+	assert := assert.New(t)
+	assert.True(kept.Allowed, "Ingress objects already using unknown providers must still be updatable, like by the controller")
+	assert.False(changed.Allowed, "Changing the providers annotation must be validated")
+}
+
+func TestThatIngressesWithMalformedCidrsAreRejected(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	ingress := BuildNamespacedIngress("team", "web", "office")
+	ingress.Annotations[IngressWhitelistAnnotation] = "1.2.3.4/32,300.1.1.1"
+
+	response := SendAdmissionReview(webhook, "Ingress", "CREATE", "team", "", ingress)
+
+	assert := assert.New(t)
+	assert.False(response.Allowed, "Ingress objects with malformed CIDRs must be rejected")
+	assert.Equal("Invalid CIDRs in the 'ingress.kubernetes.io/whitelist-source-range' annotation: 300.1.1.1", response.Result.Message)
+}
+
+func TestThatConfigMapChangesDeletingUsedProvidersAreRejected(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	configMap := BuildConfigMap("dmz", map[string]string{"office": "1.2.3.4/32"})
+
+	response := SendAdmissionReview(webhook, "ConfigMap", "UPDATE", "dmz", DMZConfigMapName, configMap)
+
+	assert := assert.New(t)
+	assert.False(response.Allowed, "Deleting a provider used by Ingress objects must be rejected")
+	assert.Equal("These Ingress objects would use deleted providers: team/api (vpn)", response.Result.Message)
+}
+
+func TestThatConfigMapChangesKeepingUsedProvidersAreAllowed(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	configMap := BuildConfigMap("dmz", map[string]string{"office": "8.8.8.8/32", "vpn": "4.4.4.4/32"})
+
+	response := SendAdmissionReview(webhook, "ConfigMap", "UPDATE", "dmz", DMZConfigMapName, configMap)
+
+	assert := assert.New(t)
+	assert.True(response.Allowed, "Changing the addresses of providers must be allowed")
+}
+
+func TestThatDeletingTheCentralConfigMapIsAllowedByDefault(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+
+	response := SendAdmissionReview(webhook, "ConfigMap", "DELETE", "dmz", DMZConfigMapName, nil)
+
+	assert := assert.New(t)
+	assert.True(response.Allowed, "The ConfigMap deletion policy decides what happens once the ConfigMap is deleted")
+}
+
+func TestThatDeletingTheCentralConfigMapIsRejectedWhenIngressesUseItAndDeletionIsBlocked(t *testing.T) {
+	webhook := BuildAdmissionWebhook()
+	webhook.blockConfigMapDeletion = true
+
+	response := SendAdmissionReview(webhook, "ConfigMap", "DELETE", "dmz", DMZConfigMapName, nil)
+
+	assert := assert.New(t)
+	assert.False(response.Allowed, "Deleting the ConfigMap used by Ingress objects must be rejected")
+}
+
+func TestThatRequestsThatAreNotAdmissionReviewsFail(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	BuildAdmissionWebhook().ServeHTTP(recorder, httptest.NewRequest("POST", "/validate", bytes.NewBufferString("{}")))
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func BuildAdmissionWebhook() *AdmissionWebhook {
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"office": "1.2.3.4/32", "vpn": "4.4.4.4/32", "corporate": "@office,@missing"}))
+
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), configMapRepository)
+	whitelister.configNamespace = "dmz"
+
+	ingresses := []*repository.IngressObject{
+		BuildNamespacedIngress("team", "web", "office"),
+		BuildNamespacedIngress("team", "api", "office,vpn"),
+		BuildNamespacedIngress("team", "legacy", "removed-long-ago"),
+	}
+
+	return &AdmissionWebhook{
+		whitelister: whitelister,
+		listIngresses: func() ([]*repository.IngressObject, error) {
+			return ingresses, nil
+		},
+	}
+}
+
+func SendAdmissionReview(webhook *AdmissionWebhook, kind string, operation string, namespace string, name string, object interface{}) *AdmissionResponse {
+	request := &AdmissionRequest{
+		UID:       "request-uid",
+		Kind:      AdmissionKind{Kind: kind},
+		Namespace: namespace,
+		Name:      name,
+		Operation: operation,
+	}
+	if object != nil {
+		request.Object, _ = json.Marshal(object)
+	}
+	return sendAdmissionRequest(webhook, request)
+}
+
+func SendAdmissionUpdate(webhook *AdmissionWebhook, kind string, namespace string, name string, oldObject interface{}, object interface{}) *AdmissionResponse {
+	request := &AdmissionRequest{
+		UID:       "request-uid",
+		Kind:      AdmissionKind{Kind: kind},
+		Namespace: namespace,
+		Name:      name,
+		Operation: "UPDATE",
+	}
+	request.Object, _ = json.Marshal(object)
+	request.OldObject, _ = json.Marshal(oldObject)
+	return sendAdmissionRequest(webhook, request)
+}
+
+func sendAdmissionRequest(webhook *AdmissionWebhook, request *AdmissionRequest) *AdmissionResponse {
+	body, _ := json.Marshal(AdmissionReview{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview", Request: request})
+
+	recorder := httptest.NewRecorder()
+	webhook.ServeHTTP(recorder, httptest.NewRequest("POST", "/validate", bytes.NewBuffer(body)))
+
+	review := AdmissionReview{}
+	json.NewDecoder(recorder.Body).Decode(&review)
+	if review.Response.UID != request.UID {
+		panic("The response must belong to the request")
+	}
+	return review.Response
+}
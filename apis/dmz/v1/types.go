@@ -0,0 +1,48 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WhitelistProvider is a named list of CIDRs that Ingress objects can whitelist, like the entries of the dmz-controller ConfigMap
+type WhitelistProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              WhitelistProviderSpec   `json:"spec"`
+	Status            WhitelistProviderStatus `json:"status,omitempty"`
+}
+
+// WhitelistProviderSpec contains the addresses of the provider
+type WhitelistProviderSpec struct {
+	// Description explains what these addresses are
+	Description string `json:"description,omitempty"`
+	// Owners are the people or teams responsible for keeping these addresses up to date
+	Owners []string `json:"owners,omitempty"`
+	// CIDRs are the addresses of the provider
+	CIDRs []CIDREntry `json:"cidrs,omitempty"`
+	// Includes are the names of other providers whose addresses are part of this provider
+	Includes []string `json:"includes,omitempty"`
+}
+
+// CIDREntry is a single address of a provider
+type CIDREntry struct {
+	// CIDR is an IP address or a CIDR
+	CIDR string `json:"cidr"`
+	// Description explains what this address is
+	Description string `json:"description,omitempty"`
+	// Exclude removes this address from the whitelist instead of adding it
+	Exclude bool `json:"exclude,omitempty"`
+}
+
+// WhitelistProviderStatus is the observed state of the provider
+type WhitelistProviderStatus struct {
+	// Ingresses are the 'namespace/name' keys of the Ingress objects using this provider
+	Ingresses []string `json:"ingresses,omitempty"`
+}
+
+// WhitelistProviderList is a list of WhitelistProvider objects
+type WhitelistProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []WhitelistProvider `json:"items"`
+}
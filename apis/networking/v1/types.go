@@ -0,0 +1,84 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// PolicyTypeIngress is the policy type restricting the traffic reaching the selected pods
+	PolicyTypeIngress = "Ingress"
+	// PolicyTypeEgress is the policy type restricting the traffic leaving the selected pods
+	PolicyTypeEgress = "Egress"
+)
+
+// NetworkPolicy is the networking.k8s.io/v1 object restricting the traffic of the pods it selects.
+// The whole spec is defined, so changes made to any of its fields are seen when comparing it with the desired one.
+type NetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              NetworkPolicySpec `json:"spec"`
+}
+
+// NetworkPolicySpec selects the pods of the NetworkPolicy, and the traffic allowed to reach them
+type NetworkPolicySpec struct {
+	// PodSelector selects the pods of the namespace this NetworkPolicy applies to
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Ingress are the rules allowing traffic to the selected pods. When empty, no traffic is allowed.
+	Ingress []NetworkPolicyIngressRule `json:"ingress,omitempty"`
+	// Egress are the rules allowing traffic from the selected pods, when the Egress policy type is restricted
+	Egress []NetworkPolicyEgressRule `json:"egress,omitempty"`
+	// PolicyTypes tells which kinds of traffic are restricted
+	PolicyTypes []string `json:"policyTypes,omitempty"`
+}
+
+// NetworkPolicyIngressRule allows the traffic coming from any of its peers to any of its ports
+type NetworkPolicyIngressRule struct {
+	// Ports are the ports of the selected pods the traffic is allowed to. When empty, every port is allowed.
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+	// From are the sources allowed to reach the selected pods. When empty, every source is allowed.
+	From []NetworkPolicyPeer `json:"from,omitempty"`
+}
+
+// NetworkPolicyEgressRule allows the traffic going to any of its peers on any of its ports
+type NetworkPolicyEgressRule struct {
+	// Ports are the destination ports the traffic is allowed to. When empty, every port is allowed.
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+	// To are the destinations the selected pods are allowed to reach. When empty, every destination is allowed.
+	To []NetworkPolicyPeer `json:"to,omitempty"`
+}
+
+// NetworkPolicyPort is a port, or a range of ports, of a protocol
+type NetworkPolicyPort struct {
+	// Protocol is TCP, UDP or SCTP. The API server defaults it to TCP.
+	Protocol *string `json:"protocol,omitempty"`
+	// Port is a port number or the name of a port of the pods. When empty, every port of the protocol is allowed.
+	Port *intstr.IntOrString `json:"port,omitempty"`
+	// EndPort makes the rule allow the range of ports from Port to EndPort
+	EndPort *int32 `json:"endPort,omitempty"`
+}
+
+// NetworkPolicyPeer is a source or a destination of traffic: a range of addresses, or some pods of some namespaces
+type NetworkPolicyPeer struct {
+	// PodSelector selects pods, in the namespace of the NetworkPolicy or in the ones selected by NamespaceSelector
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// NamespaceSelector selects namespaces, whose pods are selected by PodSelector, or all of them without it
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// IPBlock is a range of addresses
+	IPBlock *IPBlock `json:"ipBlock,omitempty"`
+}
+
+// IPBlock is a CIDR, except some of its ranges
+type IPBlock struct {
+	// CIDR is the allowed range of addresses
+	CIDR string `json:"cidr"`
+	// Except are the ranges of the CIDR that are not allowed
+	Except []string `json:"except,omitempty"`
+}
+
+// NetworkPolicyList is a list of NetworkPolicy objects
+type NetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []NetworkPolicy `json:"items"`
+}
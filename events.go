@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fiunchinho/dmz-controller/remote"
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/fiunchinho/dmz-controller/writer"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// EventReasonWhitelistUpdated is used when the CIDRs managed by the controller in an Ingress or Service object change
+	EventReasonWhitelistUpdated = "WhitelistUpdated"
+	// EventReasonWhitelistRemoved is used when the managed CIDRs are removed, like when the Ingress or Service object no longer has providers
+	EventReasonWhitelistRemoved = "WhitelistRemoved"
+	// EventReasonWhitelistFailed is used when the whitelist of an Ingress or Service object can't be calculated
+	EventReasonWhitelistFailed = "WhitelistFailed"
+	// EventReasonUnknownProvider is used when an Ingress or Service object uses providers that don't exist
+	EventReasonUnknownProvider = "UnknownProvider"
+	// EventReasonInvalidCIDR is used when some addresses are skipped because they are neither IPs nor CIDRs
+	EventReasonInvalidCIDR = "InvalidCIDR"
+	// EventReasonSaveConflict is used when an Ingress or Service object changed while the controller was saving it
+	EventReasonSaveConflict = "SaveConflict"
+	// EventReasonSaveFailed is used when an Ingress object, a Service object or a NetworkPolicy can't be saved for any other reason
+	EventReasonSaveFailed = "SaveFailed"
+	// EventReasonNetworkPolicyUpdated is used when the NetworkPolicy of a Service or Deployment object is created or changed
+	EventReasonNetworkPolicyUpdated = "NetworkPolicyUpdated"
+	// EventReasonNetworkPolicyRemoved is used when the NetworkPolicy of a Service or Deployment object is deleted, like when it no longer has providers
+	EventReasonNetworkPolicyRemoved = "NetworkPolicyRemoved"
+	// EventReasonNetworkPolicyFailed is used when the NetworkPolicy of a Service or Deployment object can't be generated
+	EventReasonNetworkPolicyFailed = "NetworkPolicyFailed"
+)
+
+// event records an Event on the given object. Nothing is recorded when the whitelister has no EventRecorder.
+func (whitelister *IngressWhitelister) event(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if whitelister.recorder == nil {
+		return
+	}
+	whitelister.recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// recordFailure records a Warning Event for an error that prevented whitelisting an Ingress or Service object
+func (whitelister *IngressWhitelister) recordFailure(object runtime.Object, err error) {
+	whitelister.event(object, v1.EventTypeWarning, EventReasonWhitelistFailed, "Error calculating the whitelist: %s", err.Error())
+}
+
+// recordSaveFailure records a Warning Event for an error saving an Ingress object.
+// Conflicts are saved again right away, so they are only recorded by recordSaveConflict when they keep happening.
+func (whitelister *IngressWhitelister) recordSaveFailure(ingress *repository.IngressObject, err error) {
+	if errors.IsConflict(err) {
+		return
+	}
+	whitelister.event(ingress, v1.EventTypeWarning, EventReasonSaveFailed, "Error saving the whitelist: %s", err.Error())
+}
+
+// recordSaveConflict records a Warning Event for an Ingress object that kept changing while saving its whitelist
+func (whitelister *IngressWhitelister) recordSaveConflict(ingress *repository.IngressObject, err error) {
+	whitelister.event(ingress, v1.EventTypeWarning, EventReasonSaveConflict, "The Ingress changed while saving its whitelist, it will be retried: %s", err.Error())
+}
+
+// recordProviderWarnings records Warning Events for the unknown providers used by an Ingress object,
+// and for the invalid CIDRs of its providers and of the whitelist annotation of the given writer
+func (whitelister *IngressWhitelister) recordProviderWarnings(ingress *repository.IngressObject, output writer.Writer, annotation string, providers map[string]string) {
+	if unknown := getUnknownProviders(annotation, providers); len(unknown) > 0 {
+		whitelister.event(ingress, v1.EventTypeWarning, EventReasonUnknownProvider, "Unknown providers were skipped: %s", strings.Join(unknown, ", "))
+	}
+
+	for _, name := range getReferencedProviders(annotation, providers) {
+		if invalid := getInvalidCIDRs(providers[name]); len(invalid) > 0 {
+			whitelister.event(ingress, v1.EventTypeWarning, EventReasonInvalidCIDR, "Invalid CIDRs of provider '%s' were skipped: %s", name, strings.Join(invalid, ", "))
+		}
+	}
+
+	if invalid := writer.InvalidIPs(output, ingress.Annotations); len(invalid) > 0 {
+		whitelister.event(ingress, v1.EventTypeWarning, EventReasonInvalidCIDR, "Invalid CIDRs in the '%s' annotation were skipped: %s", output.Annotation(), strings.Join(invalid, ", "))
+	}
+}
+
+// recordWhitelistChange records a Normal Event with the CIDRs added and removed by the controller, when there are any.
+// Added CIDRs are followed by the providers they come from.
+func (whitelister *IngressWhitelister) recordWhitelistChange(object runtime.Object, annotation string, providers map[string]string, previous, current *whitelist.Whitelist) {
+	added := whitelist.NewWhitelistFromArray(current.Ips)
+	added.Remove(previous)
+	removed := whitelist.NewWhitelistFromArray(previous.Ips)
+	removed.Remove(current)
+	if len(added.Ips) == 0 && len(removed.Ips) == 0 {
+		return
+	}
+
+	changes := []string{}
+	if len(added.Ips) > 0 {
+		sources := getProviderWhitelists(annotation, providers)
+		addedCidrs := []string{}
+		for _, cidr := range added.Ips {
+			if names := getSourcesOf(cidr, sources); len(names) > 0 {
+				cidr = fmt.Sprintf("%s (%s)", cidr, strings.Join(names, ", "))
+			}
+			addedCidrs = append(addedCidrs, cidr)
+		}
+		changes = append(changes, "added "+strings.Join(addedCidrs, ", "))
+	}
+	if len(removed.Ips) > 0 {
+		changes = append(changes, "removed "+strings.Join(removed.Ips, ", "))
+	}
+
+	whitelister.event(object, v1.EventTypeNormal, EventReasonWhitelistUpdated, "Whitelist of providers '%s' updated: %s", annotation, strings.Join(changes, "; "))
+}
+
+// getProviderWhitelists returns the addresses whitelisted by each provider of a providers annotation that is not excluded
+func getProviderWhitelists(annotation string, providers map[string]string) map[string]*whitelist.Whitelist {
+	whitelists := make(map[string]*whitelist.Whitelist)
+	for _, value := range strings.Split(annotation, ",") {
+		provider := strings.TrimSpace(value)
+		if strings.HasPrefix(provider, ExcludedProviderPrefix) {
+			continue
+		}
+		if _, ok := providers[provider]; !ok {
+			continue
+		}
+		if whitelisted, _, err := resolveProvider(provider, providers, []string{}); err == nil {
+			whitelists[provider] = whitelisted
+		}
+	}
+	return whitelists
+}
+
+// getSourcesOf returns the sorted names of the providers whose addresses overlap with the given CIDR
+func getSourcesOf(cidr string, whitelists map[string]*whitelist.Whitelist) []string {
+	cidrWhitelist := whitelist.NewWhitelistFromArray([]string{cidr})
+	names := []string{}
+	for name, providerWhitelist := range whitelists {
+		if cidrWhitelist.Overlaps(providerWhitelist) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getInvalidCIDRs returns the entries of a provider that are neither IPs nor CIDRs, ignoring references to other providers
+func getInvalidCIDRs(value string) []string {
+	if remote.IsSource(value) {
+		return []string{}
+	}
+
+	addresses := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		address := strings.TrimSpace(entry)
+		if strings.HasPrefix(address, ProviderReferencePrefix) {
+			continue
+		}
+		addresses = append(addresses, strings.TrimPrefix(address, ExcludedCIDRPrefix))
+	}
+	return whitelist.InvalidIPs(strings.Join(addresses, ","))
+}
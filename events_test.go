@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestThatAddedAndRemovedCidrsAreRecordedAsEvents(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn,offices").Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"offices": "1.2.3.4/32",
+		"vpn":     "4.4.4.4/32",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+
+	assert := assert.New(t)
+	assert.NoError(whitelister.Whitelist(ingressName))
+	assert.Equal("Normal WhitelistUpdated Whitelist of providers 'vpn,offices' updated: added 1.2.3.4/32 (offices), 4.4.4.4/32 (vpn)", <-recorder.Events)
+
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"offices": "1.2.3.4/32",
+		"vpn":     "5.5.5.5/32",
+	}))
+	assert.NoError(whitelister.Whitelist(ingressName))
+	assert.Equal("Normal WhitelistUpdated Whitelist of providers 'vpn,offices' updated: added 5.5.5.5/32 (vpn); removed 4.4.4.4/32", <-recorder.Events)
+}
+
+func TestThatNoEventIsRecordedWhenTheWhitelistDoesNotChange(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+	whitelister.Whitelist(ingressName)
+	<-recorder.Events
+
+	whitelister.Whitelist(ingressName)
+
+	assert.Len(t, recorder.Events, 0, "Nothing changed, so nothing should be recorded")
+}
+
+func TestThatUnknownProvidersAndInvalidCidrsAreRecordedAsWarnings(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn,non-existing").WithAnnotation(IngressWhitelistAnnotation, "not-an-ip").Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn":     "4.4.4.4/32,1.2.3.400,-5.5.5,@offices",
+		"offices": "1.2.3.4/32",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+	whitelister.Whitelist(ingressName)
+
+	assert := assert.New(t)
+	assert.Equal("Warning UnknownProvider Unknown providers were skipped: non-existing", <-recorder.Events)
+	assert.Equal("Warning InvalidCIDR Invalid CIDRs of provider 'vpn' were skipped: 1.2.3.400, 5.5.5", <-recorder.Events)
+	assert.Equal("Warning InvalidCIDR Invalid CIDRs in the 'ingress.kubernetes.io/whitelist-source-range' annotation were skipped: not-an-ip", <-recorder.Events)
+	assert.Equal("Normal WhitelistUpdated Whitelist of providers 'vpn,non-existing' updated: added 1.2.3.4/32 (vpn), 4.4.4.4/32 (vpn)", <-recorder.Events)
+}
+
+func TestThatProvidersReferencingThemselvesAreRecordedAsWarnings(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32,@vpn",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+
+	assert := assert.New(t)
+	assert.Error(whitelister.Whitelist(ingressName))
+	assert.Equal("Warning WhitelistFailed Error calculating the whitelist: Provider 'vpn' references itself: vpn -> vpn", <-recorder.Events)
+}
+
+func TestThatSaveConflictsAreRecordedAsWarnings(t *testing.T) {
+	ingressRepository := &IngressRepositoryWithConflicts{
+		ingressObj: *BuildIngressObject().Named("my-ingress").WithAnnotation(DMZProvidersAnnotation, "vpn").Build(),
+		conflicts:  maxSaveAttempts,
+	}
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+
+	assert := assert.New(t)
+	assert.Error(whitelister.Whitelist("namespace/my-ingress"))
+	assert.Equal(maxSaveAttempts-1, ingressRepository.reloads, "The Ingress should be reloaded before each new attempt")
+	assert.Contains(<-recorder.Events, "Warning SaveConflict The Ingress changed while saving its whitelist, it will be retried")
+}
+
+func TestThatSaveConflictsAreRetriedWithTheLatestIngress(t *testing.T) {
+	ingressRepository := &IngressRepositoryWithConflicts{
+		ingressObj: *BuildIngressObject().Named("my-ingress").WithAnnotation(DMZProvidersAnnotation, "vpn").Build(),
+		conflicts:  1,
+	}
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+
+	assert := assert.New(t)
+	assert.NoError(whitelister.Whitelist("namespace/my-ingress"))
+	assert.Equal(1, ingressRepository.reloads)
+	assert.Equal("4.4.4.4/32", ingressRepository.ingressObj.Annotations[ManagedWhitelistAnnotation])
+	assert.NotContains(<-recorder.Events, EventReasonSaveConflict, "Conflicts solved by saving again are not worth an Event")
+}
+
+func TestThatRemovingTheProvidersIsRecordedAsAnEvent(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "4.4.4.4/32,9.9.9.9/32").WithAnnotation(ManagedWhitelistAnnotation, "4.4.4.4/32").Build()
+
+	ingressRepository.Save(ingress)
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.recorder = recorder
+	whitelister.Whitelist(ingressName)
+
+	assert.Equal(t, "Normal WhitelistRemoved Providers annotation was removed: removed 4.4.4.4/32", <-recorder.Events)
+}
+
+type IngressRepositoryWithConflicts struct {
+	ingressObj repository.IngressObject
+	// conflicts is how many saves fail with a conflict before saving works
+	conflicts int
+	reloads   int
+}
+
+func (m *IngressRepositoryWithConflicts) Get(namespace string, key string) (*repository.IngressObject, error) {
+	return &m.ingressObj, nil
+}
+func (m *IngressRepositoryWithConflicts) Reload(namespace string, key string) (*repository.IngressObject, error) {
+	m.reloads++
+	return &m.ingressObj, nil
+}
+func (m *IngressRepositoryWithConflicts) Save(ingress *repository.IngressObject, annotations ...string) (*repository.IngressObject, error) {
+	if m.conflicts > 0 {
+		m.conflicts--
+		return nil, apierrors.NewConflict(schema.GroupResource{Group: "extensions", Resource: "ingresses"}, ingress.Name, nil)
+	}
+	m.ingressObj = *ingress
+	return ingress, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthChecker serves the /healthz and /readyz endpoints.
+// The controller is ready once its caches are populated and its providers can be read.
+// It is alive as long as the workers keep making progress whenever there is work to do.
+type HealthChecker struct {
+	mutex sync.Mutex
+	// synced is set once the informer caches are populated
+	synced bool
+	// providersFound tells whether the central providers exist. When nil, they are not checked.
+	providersFound func() error
+	// pendingWork returns the number of keys waiting in the queue
+	pendingWork func() int
+	// stallTimeout is how long the workers can go without progress while there is work to do
+	stallTimeout time.Duration
+	// workersRunning is set while the workers are reading objects off the queue
+	workersRunning bool
+	// inFlight is the number of reconciles in progress
+	inFlight int
+	// lastProgress is the last time a worker started or finished a reconcile
+	lastProgress time.Time
+	now          func() time.Time
+}
+
+// NewHealthChecker returns a HealthChecker, which is not ready until SetSynced is called
+func NewHealthChecker(providersFound func() error, pendingWork func() int, stallTimeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		providersFound: providersFound,
+		pendingWork:    pendingWork,
+		stallTimeout:   stallTimeout,
+		now:            time.Now,
+	}
+}
+
+// SetSynced marks the informer caches as populated
+func (checker *HealthChecker) SetSynced() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.synced = true
+}
+
+// WorkersStarted marks the workers as running. Standby replicas don't run workers, so they can't stall.
+func (checker *HealthChecker) WorkersStarted() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.workersRunning = true
+	checker.lastProgress = checker.now()
+}
+
+// WorkersStopped marks the workers as no longer running
+func (checker *HealthChecker) WorkersStopped() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.workersRunning = false
+}
+
+// ReconcileStarted records that a worker started reconciling a key
+func (checker *HealthChecker) ReconcileStarted() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.inFlight++
+	checker.lastProgress = checker.now()
+}
+
+// ReconcileFinished records that a worker finished reconciling a key
+func (checker *HealthChecker) ReconcileFinished() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.inFlight--
+	checker.lastProgress = checker.now()
+}
+
+// ServeHealthz fails when the workers made no progress for longer than the stall timeout, while there was work to do
+func (checker *HealthChecker) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	checker.mutex.Lock()
+	stalledFor := checker.now().Sub(checker.lastProgress)
+	stalled := checker.workersRunning && (checker.inFlight > 0 || checker.pendingWork() > 0) && stalledFor > checker.stallTimeout
+	checker.mutex.Unlock()
+
+	if stalled {
+		http.Error(w, fmt.Sprintf("Workers made no progress for %s", stalledFor), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+// ServeReadyz fails until the informer caches are populated, and while the central providers can't be found
+func (checker *HealthChecker) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	checker.mutex.Lock()
+	synced := checker.synced
+	checker.mutex.Unlock()
+
+	if !synced {
+		http.Error(w, "Informer caches are not populated yet", http.StatusServiceUnavailable)
+		return
+	}
+	if checker.providersFound != nil {
+		if err := checker.providersFound(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatTheControllerIsNotReadyUntilCachesAreSynced(t *testing.T) {
+	checker := NewHealthChecker(nil, func() int { return 0 }, time.Minute)
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusServiceUnavailable, ServeHealthCheck(checker.ServeReadyz).Code)
+	checker.SetSynced()
+	assert.Equal(http.StatusOK, ServeHealthCheck(checker.ServeReadyz).Code)
+}
+
+func TestThatTheControllerIsNotReadyWithoutTheCentralProviders(t *testing.T) {
+	checker := NewHealthChecker(func() error { return errors.New("ConfigMap 'default/dmz-controller' not found") }, func() int { return 0 }, time.Minute)
+	checker.SetSynced()
+
+	response := ServeHealthCheck(checker.ServeReadyz)
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Contains(response.Body.String(), "ConfigMap 'default/dmz-controller' not found")
+}
+
+func TestThatStalledWorkersFailTheLivenessCheck(t *testing.T) {
+	now := time.Now()
+	checker := NewHealthChecker(nil, func() int { return 3 }, time.Minute)
+	checker.now = func() time.Time { return now }
+	checker.WorkersStarted()
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusOK, ServeHealthCheck(checker.ServeHealthz).Code, "Workers just started")
+
+	now = now.Add(time.Minute * 2)
+	assert.Equal(http.StatusInternalServerError, ServeHealthCheck(checker.ServeHealthz).Code, "There is work to do, but nothing happened for too long")
+
+	checker.ReconcileStarted()
+	assert.Equal(http.StatusOK, ServeHealthCheck(checker.ServeHealthz).Code, "A worker made progress")
+}
+
+func TestThatAReconcileStuckForTooLongFailsTheLivenessCheck(t *testing.T) {
+	now := time.Now()
+	checker := NewHealthChecker(nil, func() int { return 0 }, time.Minute)
+	checker.now = func() time.Time { return now }
+	checker.WorkersStarted()
+	checker.ReconcileStarted()
+
+	now = now.Add(time.Minute * 2)
+
+	assert.Equal(t, http.StatusInternalServerError, ServeHealthCheck(checker.ServeHealthz).Code)
+}
+
+func TestThatIdleOrStandbyWorkersAreAlive(t *testing.T) {
+	now := time.Now()
+	idle := NewHealthChecker(nil, func() int { return 0 }, time.Minute)
+	idle.now = func() time.Time { return now }
+	idle.WorkersStarted()
+	standby := NewHealthChecker(nil, func() int { return 3 }, time.Minute)
+	standby.now = func() time.Time { return now }
+
+	now = now.Add(time.Hour)
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusOK, ServeHealthCheck(idle.ServeHealthz).Code, "There is nothing to do")
+	assert.Equal(http.StatusOK, ServeHealthCheck(standby.ServeHealthz).Code, "Standby replicas don't run workers")
+}
+
+func ServeHealthCheck(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"github.com/fiunchinho/dmz-controller/remote"
 	"github.com/fiunchinho/dmz-controller/repository"
 	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/fiunchinho/dmz-controller/writer"
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	// IngressWhitelistAnnotation is the whitelist annotation used by the Kubernetes Ingress
+	// IngressWhitelistAnnotation is the whitelist annotation written by default, for Ingress objects of classes without a writer
 	IngressWhitelistAnnotation = "ingress.kubernetes.io/whitelist-source-range"
 
 	// DMZProvidersAnnotation is the Ingress annotation that contains will trigger this controller
@@ -19,12 +28,46 @@ const (
 
 	// ManagedWhitelistAnnotation is the name of the internal annotation used to keep track of the CIDRs managed by the controller
 	ManagedWhitelistAnnotation = "armesto.net/dmz-controller-managed-cidr"
+
+	// ManagedWhitelistTargetAnnotation is the name of the internal annotation used to keep track of the annotation where the managed CIDRs were written
+	ManagedWhitelistTargetAnnotation = "armesto.net/dmz-controller-managed-annotation"
+
+	// ExcludedProviderPrefix marks a provider in the providers annotation whose addresses must be removed from the whitelist
+	ExcludedProviderPrefix = "!"
+
+	// ExcludedCIDRPrefix marks a CIDR in the ConfigMap that must be removed from the whitelist
+	ExcludedCIDRPrefix = "-"
+
+	// ProviderReferencePrefix marks an entry in the ConfigMap that includes all the addresses of another provider
+	ProviderReferencePrefix = "@"
+
+	// ConfigMapDeletionPolicyKeep leaves the whitelists as they are while the central ConfigMap doesn't exist
+	ConfigMapDeletionPolicyKeep = "keep"
+	// ConfigMapDeletionPolicyRemove removes the managed CIDRs of every Ingress object while the central ConfigMap doesn't exist
+	ConfigMapDeletionPolicyRemove = "remove"
+
+	// maxSaveAttempts is how many times the whitelist of an object is calculated and saved when the object keeps changing meanwhile
+	maxSaveAttempts = 3
 )
 
 // IngressWhitelister to process watched Ingress objects
 type IngressWhitelister struct {
 	ingressRepository   repository.IngressRepository
 	configMapRepository repository.ConfigMapRepository
+	// providerRepository gives access to WhitelistProvider objects. When nil, providers only come from ConfigMaps.
+	providerRepository repository.WhitelistProviderRepository
+	// remoteFetcher downloads the addresses of remote providers. When nil, remote providers are skipped.
+	remoteFetcher *remote.Fetcher
+	// configNamespace is the namespace of the central ConfigMap. When empty, the ConfigMap in the Ingress namespace is used.
+	configNamespace string
+	// aggregate makes the controller write the smallest equivalent list of CIDRs coming from providers
+	aggregate bool
+	// recorder records Events on the Ingress objects about their whitelist. When nil, no Events are recorded.
+	recorder record.EventRecorder
+	// configMapDeletionPolicy tells what happens to the whitelists while the central ConfigMap doesn't exist. Empty means keep.
+	configMapDeletionPolicy string
+	// writers chooses the annotation where the whitelist is written, by the ingress class. When nil, writer.Legacy is always used.
+	writers *writer.Selector
 }
 
 // Whitelist adds the desired addresses as whitelisted to the given Ingress object
@@ -42,45 +85,455 @@ func (whitelister *IngressWhitelister) Whitelist(key string) error {
 	}
 	glog.V(0).Infof("Got '%s/%s' Ingress object from cache.", namespace, name)
 
-	configMap, err := whitelister.configMapRepository.Get(namespace, DMZConfigMapName)
-	if err != nil {
-		return err
+	// Any write to the Ingress object conflicts with saving the whitelist, even when it didn't touch the whitelist,
+	// so the whitelist is calculated again from the latest Ingress object a few times before requeuing it
+	for attempt := 1; ; attempt++ {
+		err = whitelister.reconcile(namespace, name, ingress)
+		if !errors.IsConflict(err) || attempt == maxSaveAttempts {
+			break
+		}
+		glog.V(0).Infof("Ingress '%s/%s' changed while saving its whitelist, calculating it again", namespace, name)
+		if ingress, err = whitelister.ingressRepository.Reload(namespace, name); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	if errors.IsConflict(err) {
+		whitelister.recordSaveConflict(ingress, err)
 	}
-	glog.V(1).Infof("Got '%s' ConfigMap from cache, with the following data: %s", DMZConfigMapName, configMap.Data)
+	return err
+}
 
+// reconcile adds the desired addresses as whitelisted to the given Ingress object
+func (whitelister *IngressWhitelister) reconcile(namespace string, name string, ingress *repository.IngressObject) error {
 	provider, ok := ingress.Annotations[DMZProvidersAnnotation]
-	if ok {
-		currentWhitelistedIps := whitelist.NewWhitelistFromString(ingress.Annotations[IngressWhitelistAnnotation])
+	if !ok {
 		if _, ok := ingress.Annotations[ManagedWhitelistAnnotation]; ok {
-			currentWhitelistedIps.Minus(whitelist.NewWhitelistFromString(ingress.Annotations[ManagedWhitelistAnnotation]))
+			return whitelister.unmanage(ingress, "Providers annotation was removed")
+		}
+		return nil
+	}
+
+	providers, err := whitelister.getProviders(namespace)
+	if errors.IsNotFound(err) {
+		return whitelister.applyConfigMapDeletionPolicy(ingress, "Ingress", "whitelist", func(reason string) error {
+			if _, ok := ingress.Annotations[ManagedWhitelistAnnotation]; !ok {
+				return nil
+			}
+			return whitelister.unmanage(ingress, reason)
+		})
+	}
+	if err != nil {
+		whitelister.recordFailure(ingress, err)
+		return err
+	}
+
+	output := whitelister.writerFor(ingress)
+	previouslyManagedIps := whitelist.NewWhitelistFromString(ingress.Annotations[ManagedWhitelistAnnotation])
+	currentWhitelistedIps := writer.Read(output, ingress.Annotations)
+	previous, managed := managedWriterFor(ingress)
+	moving := managed && previous.Annotation() != output.Annotation()
+	if moving {
+		// The managed CIDRs were written by another writer, so the addresses whitelisted manually there are moved along
+		manualIps := writer.Read(previous, ingress.Annotations)
+		manualIps.RemoveCovered(previouslyManagedIps)
+		currentWhitelistedIps.Merge(manualIps)
+	} else {
+		currentWhitelistedIps.RemoveCovered(previouslyManagedIps)
+	}
+
+	if err := whitelister.resolveRemoteProviders(provider, providers); err != nil {
+		whitelister.recordFailure(ingress, err)
+		return err
+	}
+
+	recordProviderMetrics(namespace, provider, providers)
+	whitelister.recordProviderWarnings(ingress, output, provider, providers)
+
+	whitelistToApply, err := getWhitelistFromProvider(provider, providers)
+	if err != nil {
+		whitelister.recordFailure(ingress, err)
+		return err
+	}
+	if whitelister.aggregate {
+		whitelistToApply.Aggregate()
+	}
+	glog.V(0).Infof("Whitelisting the Ingress object with %s IPs: %s", provider, whitelistToApply.ToString())
+	desired := newDesiredIngress(ingress)
+	desired.Annotations[ManagedWhitelistAnnotation] = whitelistToApply.ToString()
+	desired.Annotations[ManagedWhitelistTargetAnnotation] = output.Annotation()
+	managedIps := whitelist.NewWhitelistFromArray(whitelistToApply.Ips)
+	whitelistToApply.Merge(currentWhitelistedIps)
+	writer.Write(output, desired.Annotations, whitelistToApply)
+	annotations := []string{output.Annotation(), ManagedWhitelistAnnotation, ManagedWhitelistTargetAnnotation}
+	if moving {
+		glog.V(0).Infof("Moving the whitelist of Ingress '%s/%s' from the '%s' annotation to '%s'", namespace, name, previous.Annotation(), output.Annotation())
+		delete(desired.Annotations, previous.Annotation())
+		annotations = append(annotations, previous.Annotation())
+	}
+
+	// Resyncs and provider changes reconcile every Ingress object, but most of them are already up to date
+	if sameWhitelist(output, ingress.Annotations, desired.Annotations) {
+		glog.V(1).Infof("Whitelist of Ingress '%s/%s' is up to date, skipping the write", namespace, name)
+		skippedWrites.WithLabelValues(namespace).Inc()
+		managedCidrs.WithLabelValues(namespace, name).Set(float64(len(managedIps.Ips)))
+		return nil
+	}
+
+	// Once the whitelist has been updated, we will update the resource accordingly.
+	// If this request fails, this item will be requeued, and the whitelist will be calculated again from the observed state
+	if _, err := whitelister.ingressRepository.Save(desired, annotations...); err != nil {
+		whitelister.recordSaveFailure(ingress, err)
+		return err
+	}
+	glog.V(0).Infof("Saved changes to Ingress resource '%s'", ingress.Name)
+	managedCidrs.WithLabelValues(namespace, name).Set(float64(len(managedIps.Ips)))
+	whitelister.recordWhitelistChange(ingress, provider, providers, previouslyManagedIps, managedIps)
+
+	return nil
+}
+
+// getProviders returns the providers available for Ingress objects in the given namespace.
+// Providers come from the central namespace, and the ones in the Ingress namespace override them.
+// The central ConfigMap is optional when the Ingress namespace has its own ConfigMap, which then has to exist.
+func (whitelister *IngressWhitelister) getProviders(namespace string) (map[string]string, error) {
+	configNamespace := whitelister.getConfigNamespace(namespace)
+
+	providers := make(map[string]string)
+	centralErr := whitelister.addProviders(providers, configNamespace, true)
+	if centralErr != nil && (!errors.IsNotFound(centralErr) || namespace == configNamespace) {
+		return nil, centralErr
+	}
+	if namespace != configNamespace {
+		if err := whitelister.addProviders(providers, namespace, centralErr != nil); err != nil {
+			if errors.IsNotFound(err) {
+				// Neither ConfigMap exists, which is handled as a missing central ConfigMap
+				return nil, centralErr
+			}
+			return nil, err
+		}
+	}
+
+	return providers, nil
+}
+
+// resolveRemoteProviders replaces the definition of the remote providers used by a providers annotation with their addresses
+func (whitelister *IngressWhitelister) resolveRemoteProviders(annotation string, providers map[string]string) error {
+	for _, name := range getReferencedProviders(annotation, providers) {
+		if !remote.IsSource(providers[name]) {
+			continue
 		}
-		whitelistToApply := getWhitelistFromProvider(provider, configMap.Data)
-		glog.V(0).Infof("Whitelisting the Ingress object with %s IPs: %s", provider, whitelistToApply.ToString())
-		ingress.Annotations[ManagedWhitelistAnnotation] = whitelistToApply.ToString()
-		whitelistToApply.Merge(currentWhitelistedIps)
-		ingress.Annotations[IngressWhitelistAnnotation] = whitelistToApply.ToString()
 
-		// Once the whitelist has been updated, we will update the resource accordingly.
-		// If this request fails, this item will be requeued
-		if _, err := whitelister.ingressRepository.Save(ingress); err != nil {
+		if whitelister.remoteFetcher == nil {
+			glog.Warningf("Skipping remote provider '%s', because remote providers are disabled", name)
+			providers[name] = ""
+			continue
+		}
+
+		source, err := remote.ParseSource(providers[name])
+		if err != nil {
+			return fmt.Errorf("Invalid remote provider '%s': %s", name, err.Error())
+		}
+		addresses, err := whitelister.remoteFetcher.Addresses(source)
+		if err != nil {
+			return fmt.Errorf("Error fetching remote provider '%s': %s", name, err.Error())
+		}
+		providers[name] = strings.Join(addresses, ",")
+	}
+
+	return nil
+}
+
+// getConfigNamespace returns the namespace of the central providers for Ingress objects in the given namespace
+func (whitelister *IngressWhitelister) getConfigNamespace(namespace string) string {
+	if whitelister.configNamespace == "" {
+		return namespace
+	}
+	return whitelister.configNamespace
+}
+
+// addProviders adds to the given map the providers defined in a namespace, both in the ConfigMap and as WhitelistProvider objects.
+// When required, a missing ConfigMap is an error, unless providers can also be defined as WhitelistProvider objects.
+func (whitelister *IngressWhitelister) addProviders(providers map[string]string, namespace string, required bool) error {
+	if whitelister.configMapRepository != nil {
+		configMap, err := whitelister.configMapRepository.Get(namespace, DMZConfigMapName)
+		if err != nil && (!errors.IsNotFound(err) || (required && whitelister.providerRepository == nil)) {
 			return err
 		}
-		glog.V(0).Infof("Saved changes to Ingress resource '%s'", ingress.Name)
+		if err == nil {
+			glog.V(1).Infof("Got '%s/%s' ConfigMap from cache, with the following data: %s", namespace, DMZConfigMapName, configMap.Data)
+			for name, value := range configMap.Data {
+				providers[name] = value
+			}
+		}
+	}
+
+	if whitelister.providerRepository != nil {
+		whitelistProviders, err := whitelister.providerRepository.List(namespace)
+		if err != nil {
+			return err
+		}
+		for _, provider := range whitelistProviders {
+			providers[provider.Name] = getProviderAddresses(provider)
+		}
 	}
 
 	return nil
 }
 
-func getWhitelistFromProvider(providers string, whitelistProviders map[string]string) *whitelist.Whitelist {
+// getProviderAddresses converts a WhitelistProvider object to the format used by the ConfigMap
+func getProviderAddresses(provider *dmzv1.WhitelistProvider) string {
+	addresses := []string{}
+	for _, entry := range provider.Spec.CIDRs {
+		if entry.Exclude {
+			addresses = append(addresses, ExcludedCIDRPrefix+entry.CIDR)
+		} else {
+			addresses = append(addresses, entry.CIDR)
+		}
+	}
+	for _, include := range provider.Spec.Includes {
+		addresses = append(addresses, ProviderReferencePrefix+include)
+	}
+	return strings.Join(addresses, ",")
+}
+
+// applyConfigMapDeletionPolicy decides what happens to an object while the central ConfigMap of its namespace doesn't exist.
+// With the remove policy, the given function removes what the controller wrote for the object, because of the given reason.
+// Otherwise, what the controller wrote is kept, and a warning with the given description of what is kept is logged.
+// It's not recorded as an Event, because it would be recorded again on every resync until the ConfigMap is created back.
+func (whitelister *IngressWhitelister) applyConfigMapDeletionPolicy(object runtime.Object, kind string, kept string, remove func(reason string) error) error {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	configNamespace := whitelister.getConfigNamespace(accessor.GetNamespace())
+	if whitelister.configMapDeletionPolicy == ConfigMapDeletionPolicyRemove {
+		return remove(fmt.Sprintf("The '%s/%s' ConfigMap doesn't exist", configNamespace, DMZConfigMapName))
+	}
+
+	glog.Warningf("The '%s/%s' ConfigMap doesn't exist, keeping the %s of %s '%s/%s'", configNamespace, DMZConfigMapName, kept, kind, accessor.GetNamespace(), accessor.GetName())
+	return nil
+}
+
+// unmanage removes the addresses managed by this controller from an Ingress object, because of the given reason.
+// Addresses that were whitelisted manually are kept, and the internal annotations are deleted.
+func (whitelister *IngressWhitelister) unmanage(ingress *repository.IngressObject, reason string) error {
+	output, ok := managedWriterFor(ingress)
+	if !ok {
+		output = whitelister.writerFor(ingress)
+	}
+	managedIps := whitelist.NewWhitelistFromString(ingress.Annotations[ManagedWhitelistAnnotation])
+	currentWhitelistedIps := writer.Read(output, ingress.Annotations)
+	currentWhitelistedIps.RemoveCovered(managedIps)
+
+	desired := newDesiredIngress(ingress)
+	delete(desired.Annotations, ManagedWhitelistAnnotation)
+	delete(desired.Annotations, ManagedWhitelistTargetAnnotation)
+	if len(currentWhitelistedIps.Ips) == 0 {
+		delete(desired.Annotations, output.Annotation())
+	} else {
+		writer.Write(output, desired.Annotations, currentWhitelistedIps)
+	}
+
+	if _, err := whitelister.ingressRepository.Save(desired, output.Annotation(), ManagedWhitelistAnnotation, ManagedWhitelistTargetAnnotation); err != nil {
+		whitelister.recordSaveFailure(ingress, err)
+		return err
+	}
+	glog.V(0).Infof("%s for Ingress '%s/%s'. Removed managed IPs: %s", reason, ingress.Namespace, ingress.Name, managedIps.ToString())
+	managedCidrs.DeleteLabelValues(ingress.Namespace, ingress.Name)
+	if len(managedIps.Ips) > 0 {
+		whitelister.event(ingress, v1.EventTypeNormal, EventReasonWhitelistRemoved, "%s: removed %s", reason, managedIps.ToString())
+	}
+
+	return nil
+}
+
+// sameWhitelist tells whether the whitelist annotation of the given writer and the managed annotation of both annotation maps
+// contain the same CIDRs, no matter their order, spacing or whether bare IPs have a prefix length. Invalid CIDRs are always written away.
+// The annotation recording where the managed CIDRs were written has to be the same too.
+func sameWhitelist(output writer.Writer, current map[string]string, desired map[string]string) bool {
+	if current[ManagedWhitelistTargetAnnotation] != desired[ManagedWhitelistTargetAnnotation] {
+		return false
+	}
+	if _, ok := current[output.Annotation()]; !ok || len(writer.InvalidIPs(output, current)) > 0 {
+		return false
+	}
+	if writer.Read(output, current).ToString() != writer.Read(output, desired).ToString() {
+		return false
+	}
+
+	currentValue, currentOk := current[ManagedWhitelistAnnotation]
+	desiredValue, desiredOk := desired[ManagedWhitelistAnnotation]
+	if currentOk != desiredOk || len(whitelist.InvalidIPs(currentValue)) > 0 {
+		return false
+	}
+	return whitelist.NewWhitelistFromString(currentValue).ToString() == whitelist.NewWhitelistFromString(desiredValue).ToString()
+}
+
+// writerFor returns the Writer of the annotation where the whitelist of an Ingress object is written
+func (whitelister *IngressWhitelister) writerFor(ingress *repository.IngressObject) writer.Writer {
+	if whitelister.writers == nil {
+		return writer.Legacy
+	}
+	return whitelister.writers.For(ingress.Class())
+}
+
+// managedWriterFor returns the Writer of the annotation where the managed CIDRs of an Ingress object were written,
+// and false when the Ingress object has no managed CIDRs or they were written to an unknown annotation.
+// Managed CIDRs written before that annotation was tracked are in the legacy annotation.
+func managedWriterFor(ingress *repository.IngressObject) (writer.Writer, bool) {
+	if _, ok := ingress.Annotations[ManagedWhitelistAnnotation]; !ok {
+		return nil, false
+	}
+	annotation, ok := ingress.Annotations[ManagedWhitelistTargetAnnotation]
+	if !ok {
+		return writer.Legacy, true
+	}
+	return writer.ForAnnotation(annotation)
+}
+
+// newDesiredIngress returns a copy of the observed Ingress object with its own annotations,
+// so the desired state can be set without changing the observed state
+func newDesiredIngress(observed *repository.IngressObject) *repository.IngressObject {
+	desired := *observed
+	desired.Annotations = make(map[string]string, len(observed.Annotations))
+	for name, value := range observed.Annotations {
+		desired.Annotations[name] = value
+	}
+	return &desired
+}
+
+func getWhitelistFromProvider(providers string, whitelistProviders map[string]string) (*whitelist.Whitelist, error) {
 	whitelistToApply := whitelist.NewEmptyWhitelist()
+	excludedIps := whitelist.NewEmptyWhitelist()
 	for _, value := range strings.Split(providers, ",") {
 		provider := strings.TrimSpace(value)
+		excluded := strings.HasPrefix(provider, ExcludedProviderPrefix)
+		provider = strings.TrimSpace(strings.TrimPrefix(provider, ExcludedProviderPrefix))
 		if _, ok := whitelistProviders[provider]; ok {
-			ipsToWhitelist := whitelistProviders[provider]
-			providerWhitelist := whitelist.NewWhitelistFromString(ipsToWhitelist)
-			whitelistToApply.Merge(providerWhitelist)
+			ipsToWhitelist, ipsToExclude, err := resolveProvider(provider, whitelistProviders, []string{})
+			if err != nil {
+				return nil, err
+			}
+			if excluded {
+				excludedIps.Merge(ipsToWhitelist)
+			} else {
+				whitelistToApply.Merge(ipsToWhitelist)
+				excludedIps.Merge(ipsToExclude)
+			}
+		}
+	}
+
+	// Exclusions always win, no matter the order of the providers
+	whitelistToApply.Minus(excludedIps)
+
+	return whitelistToApply, nil
+}
+
+// resolveProvider returns the addresses of a provider to whitelist and to exclude, including the addresses of the providers it references.
+// The path contains the providers being resolved, so reference cycles can be detected.
+func resolveProvider(provider string, whitelistProviders map[string]string, path []string) (*whitelist.Whitelist, *whitelist.Whitelist, error) {
+	for i, visited := range path {
+		if visited == provider {
+			return nil, nil, fmt.Errorf("Provider '%s' references itself: %s", provider, strings.Join(append(path[i:], provider), " -> "))
+		}
+	}
+	path = append(path, provider)
+
+	whitelisted := whitelist.NewEmptyWhitelist()
+	excluded := whitelist.NewEmptyWhitelist()
+	for _, value := range strings.Split(whitelistProviders[provider], ",") {
+		ip := strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(ip, ProviderReferencePrefix):
+			reference := strings.TrimSpace(strings.TrimPrefix(ip, ProviderReferencePrefix))
+			if _, ok := whitelistProviders[reference]; !ok {
+				glog.Warningf("Provider '%s' references the non existing provider '%s'", provider, reference)
+				continue
+			}
+			referencedWhitelisted, referencedExcluded, err := resolveProvider(reference, whitelistProviders, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			whitelisted.Merge(referencedWhitelisted)
+			excluded.Merge(referencedExcluded)
+		case strings.HasPrefix(ip, ExcludedCIDRPrefix):
+			excluded.Add([]string{strings.TrimPrefix(ip, ExcludedCIDRPrefix)})
+		default:
+			whitelisted.Add([]string{ip})
+		}
+	}
+
+	return whitelisted, excluded, nil
+}
+
+// getReferencedProviders returns the sorted names of the existing providers used by a providers annotation,
+// including the ones referenced by composite providers
+func getReferencedProviders(providers string, whitelistProviders map[string]string) []string {
+	referenced := make(map[string]bool)
+	for _, value := range strings.Split(providers, ",") {
+		provider := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), ExcludedProviderPrefix))
+		addReferencedProviders(provider, whitelistProviders, referenced)
+	}
+
+	names := make([]string, 0, len(referenced))
+	for name := range referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addReferencedProviders adds the given provider, and the providers it references, to the referenced set
+func addReferencedProviders(provider string, whitelistProviders map[string]string, referenced map[string]bool) {
+	if _, ok := whitelistProviders[provider]; !ok || referenced[provider] {
+		return
+	}
+	referenced[provider] = true
+	if remote.IsSource(whitelistProviders[provider]) {
+		return
+	}
+
+	for _, value := range strings.Split(whitelistProviders[provider], ",") {
+		entry := strings.TrimSpace(value)
+		if strings.HasPrefix(entry, ProviderReferencePrefix) {
+			addReferencedProviders(strings.TrimSpace(strings.TrimPrefix(entry, ProviderReferencePrefix)), whitelistProviders, referenced)
 		}
 	}
+}
 
-	return whitelistToApply
+// getUnknownProviders returns the sorted names of the providers used by a providers annotation that don't exist,
+// including the ones referenced by composite providers
+func getUnknownProviders(providers string, whitelistProviders map[string]string) []string {
+	unknown := make(map[string]bool)
+	for _, value := range strings.Split(providers, ",") {
+		provider := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), ExcludedProviderPrefix))
+		if _, ok := whitelistProviders[provider]; provider != "" && !ok {
+			unknown[provider] = true
+		}
+	}
+	for _, provider := range getReferencedProviders(providers, whitelistProviders) {
+		if remote.IsSource(whitelistProviders[provider]) {
+			continue
+		}
+		for _, value := range strings.Split(whitelistProviders[provider], ",") {
+			entry := strings.TrimSpace(value)
+			if !strings.HasPrefix(entry, ProviderReferencePrefix) {
+				continue
+			}
+			reference := strings.TrimSpace(strings.TrimPrefix(entry, ProviderReferencePrefix))
+			if _, ok := whitelistProviders[reference]; !ok {
+				unknown[reference] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(unknown))
+	for name := range unknown {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"errors"
 
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"github.com/fiunchinho/dmz-controller/remote"
 	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/writer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestIpsAreAdded(t *testing.T) {
@@ -30,6 +36,7 @@ func TestIpsAreAdded(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "4.4.4.4/32", "IP is missing")
@@ -40,7 +47,7 @@ func TestThatNothingChangesWhenTheDMZAnnotationIsNotPresent(t *testing.T) {
 	ingressRepository := repository.NewFakeIngressRepository()
 	configMapRepository := repository.NewFakeConfigMapRepository()
 	ingressName := "namespace/my-ingress"
-	ingress := &v1beta1.Ingress{}
+	ingress := &repository.IngressObject{}
 	ingress.Name = ingressName
 
 	ingressRepository.Save(ingress)
@@ -54,6 +61,7 @@ func TestThatNothingChangesWhenTheDMZAnnotationIsNotPresent(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation, "It's not annotated to be whitelisted")
@@ -77,6 +85,7 @@ func TestThatAssignIpsWhenThereAreTwoIpSources(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "4.4.4.4/32", "IP is missing")
@@ -100,6 +109,7 @@ func TestThatItKeepsExistingWhitelistedIpsNotManagedByTheController(t *testing.T
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "1.2.3.4/32", "IP is missing")
@@ -125,6 +135,7 @@ func TestUpdatesIpsWhenProviderChanges(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	configMap.Data = map[string]string{
 		"offices": "1.2.3.4/32",
@@ -133,6 +144,7 @@ func TestUpdatesIpsWhenProviderChanges(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.NotContains(ingress.Annotations[IngressWhitelistAnnotation], "4.4.4.4/32", "Old IP was not removed")
@@ -140,6 +152,52 @@ func TestUpdatesIpsWhenProviderChanges(t *testing.T) {
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "1.2.3.4/32", "IP is missing")
 }
 
+func TestThatItKeepsManualRangesOverlappingManagedRanges(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "10.0.0.0/16,10.0.5.0/24").WithAnnotation(ManagedWhitelistAnnotation, "10.0.5.0/24").WithAnnotation(DMZProvidersAnnotation, "offices").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "1.2.3.4/32",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4/32,10.0.0.0/16", ingress.Annotations[IngressWhitelistAnnotation], "Manual range must be kept untouched")
+}
+
+func TestThatItRemovesManagedRangesWrittenDifferently(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "10.0.0.0/24,10.0.1.0/24,9.9.9.9/32").WithAnnotation(ManagedWhitelistAnnotation, "10.0.0.0/23").WithAnnotation(DMZProvidersAnnotation, "offices").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "1.2.3.4/32",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4/32,9.9.9.9/32", ingress.Annotations[IngressWhitelistAnnotation], "Ranges inside the aggregated managed range must be removed")
+}
+
 func TestThatItSkipsNonExistingProviders(t *testing.T) {
 	ingressRepository := repository.NewFakeIngressRepository()
 	configMapRepository := repository.NewFakeConfigMapRepository()
@@ -158,12 +216,405 @@ func TestThatItSkipsNonExistingProviders(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "4.4.4.4/32", "IP is missing")
 	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "1.2.3.4/32", "IP is missing")
 }
 
+func TestThatManagedIpsAreRemovedWhenProvidersAnnotationIsRemoved(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "1.2.3.4/32,123.1.2.3/32").WithAnnotation(ManagedWhitelistAnnotation, "1.2.3.4/32").Build()
+
+	ingressRepository.Save(ingress)
+
+	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("123.1.2.3/32", ingress.Annotations[IngressWhitelistAnnotation], "Only the manually whitelisted IP should be kept")
+	assert.NotContains(ingress.Annotations, ManagedWhitelistAnnotation, "The managed annotation should be removed")
+}
+
+func TestThatWhitelistAnnotationIsRemovedWhenAllIpsWereManaged(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "1.2.3.4/32,4.4.4.4/32").WithAnnotation(ManagedWhitelistAnnotation, "1.2.3.4/32,4.4.4.4/32").Build()
+
+	ingressRepository.Save(ingress)
+
+	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation, "There are no IPs left to whitelist")
+	assert.NotContains(ingress.Annotations, ManagedWhitelistAnnotation, "The managed annotation should be removed")
+}
+
+func TestThatWhitelistIsKeptWhenTheCentralConfigMapIsDeleted(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").WithAnnotation(IngressWhitelistAnnotation, "4.4.4.4/32,9.9.9.9/32").WithAnnotation(ManagedWhitelistAnnotation, "4.4.4.4/32").Build()
+
+	ingressRepository.Save(ingress)
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, NewConfigMapRepositoryByNamespace())
+	whitelister.configNamespace = "dmz"
+	whitelister.recorder = recorder
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err, "A missing ConfigMap should not be retried over and over")
+	assert.Equal("4.4.4.4/32,9.9.9.9/32", ingress.Annotations[IngressWhitelistAnnotation], "The last known whitelist should be kept")
+	assert.Equal("4.4.4.4/32", ingress.Annotations[ManagedWhitelistAnnotation], "The last known whitelist should be kept")
+	assert.Empty(recorder.Events, "Nothing changes until the ConfigMap is created back, so no Event is recorded on every resync")
+}
+
+func TestThatManagedIpsAreRemovedWhenTheCentralConfigMapIsDeletedWithTheRemovePolicy(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").WithAnnotation(IngressWhitelistAnnotation, "4.4.4.4/32,9.9.9.9/32").WithAnnotation(ManagedWhitelistAnnotation, "4.4.4.4/32").Build()
+
+	ingressRepository.Save(ingress)
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, NewConfigMapRepositoryByNamespace())
+	whitelister.configNamespace = "dmz"
+	whitelister.configMapDeletionPolicy = ConfigMapDeletionPolicyRemove
+	whitelister.recorder = recorder
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("9.9.9.9/32", ingress.Annotations[IngressWhitelistAnnotation], "Only the manually whitelisted IP should be kept")
+	assert.NotContains(ingress.Annotations, ManagedWhitelistAnnotation, "The managed annotation should be removed")
+	assert.Equal("vpn", ingress.Annotations[DMZProvidersAnnotation], "The providers should be whitelisted again once the ConfigMap is back")
+	assert.Equal("Normal WhitelistRemoved The 'dmz/dmz-controller' ConfigMap doesn't exist: removed 4.4.4.4/32", <-recorder.Events)
+}
+
+func TestThatProvidersAreReadFromTheCentralConfigMap(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.configNamespace = "dmz"
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("4.4.4.4/32", ingress.Annotations[IngressWhitelistAnnotation], "IP from the central ConfigMap is missing")
+}
+
+func TestThatNamespaceConfigMapOverridesCentralProviders(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn,offices").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32", "offices": "1.2.3.4/32"}))
+	configMapRepository.Save(BuildConfigMap("team", map[string]string{"vpn": "8.8.8.8/32"}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.configNamespace = "dmz"
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "8.8.8.8/32", "IP from the namespace ConfigMap is missing")
+	assert.Contains(ingress.Annotations[IngressWhitelistAnnotation], "1.2.3.4/32", "IP from the central ConfigMap is missing")
+	assert.NotContains(ingress.Annotations[IngressWhitelistAnnotation], "4.4.4.4/32", "Overridden IP should not be here")
+}
+
+func TestThatTheCentralConfigMapIsOptionalWhenTheNamespaceHasItsOwn(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("team", map[string]string{"vpn": "8.8.8.8/32"}))
+
+	recorder := record.NewFakeRecorder(10)
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.configNamespace = "dmz"
+	whitelister.recorder = recorder
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("8.8.8.8/32", ingress.Annotations[IngressWhitelistAnnotation], "Providers of the namespace ConfigMap should be whitelisted")
+	assert.Contains(<-recorder.Events, EventReasonWhitelistUpdated, "The namespace ConfigMap is enough")
+}
+
+func TestThatProviderIpsAreAggregatedWhenEnabled(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(IngressWhitelistAnnotation, "123.1.2.3/32").WithAnnotation(DMZProvidersAnnotation, "vpn,offices").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "10.0.0.0/25,10.0.0.5",
+			"vpn":     "10.0.0.128/25",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.aggregate = true
+	whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("10.0.0.0/24", ingress.Annotations[ManagedWhitelistAnnotation], "Provider IPs should be aggregated")
+	assert.Equal("10.0.0.0/24,123.1.2.3/32", ingress.Annotations[IngressWhitelistAnnotation], "Manually whitelisted IPs should be kept")
+}
+
+func TestThatExcludedProvidersAreSubtracted(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "!quarantine,partner").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"partner":    "10.0.0.0/22",
+			"quarantine": "10.0.1.0/24",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("10.0.0.0/24,10.0.2.0/23", ingress.Annotations[IngressWhitelistAnnotation], "Quarantined range should be excluded")
+}
+
+func TestThatExcludedCidrsInProvidersAreSubtracted(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "offices,partner").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "1.2.3.4/32",
+			"partner": "10.0.0.0/23, -10.0.1.0/24",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4/32,10.0.0.0/24", ingress.Annotations[IngressWhitelistAnnotation], "Excluded range should be removed")
+}
+
+func TestThatProvidersCanIncludeOtherProviders(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "corporate").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices":   "1.2.3.4/32",
+			"vpn":       "4.4.4.4/32",
+			"ci":        "8.8.8.0/24,-8.8.8.128/25",
+			"internal":  "@offices,@vpn",
+			"corporate": "@internal, @ci, 5.5.5.5",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("1.2.3.4/32,4.4.4.4/32,5.5.5.5/32,8.8.8.0/25", ingress.Annotations[IngressWhitelistAnnotation], "Referenced providers should be included")
+}
+
+func TestThatItFailsWhenProvidersReferenceEachOther(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "corporate").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices":   "1.2.3.4/32,@internal",
+			"internal":  "@offices",
+			"corporate": "@internal",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.EqualError(err, "Provider 'internal' references itself: internal -> offices -> internal")
+	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation, "Nothing should be whitelisted")
+}
+
+func TestThatProvidersCanBeWhitelistProviderObjects(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "team/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "partner").Build()
+
+	ingressRepository.Save(ingress)
+
+	providerRepository := repository.NewFakeWhitelistProviderRepository()
+	providerRepository.Save(BuildWhitelistProvider("dmz", "vpn", dmzv1.CIDREntry{CIDR: "4.4.4.4"}))
+	providerRepository.Save(BuildWhitelistProvider("team", "partner", dmzv1.CIDREntry{CIDR: "10.0.0.0/23"}, dmzv1.CIDREntry{CIDR: "10.0.1.0/24", Exclude: true}))
+	partner, _ := providerRepository.Get("team", "partner")
+	partner.Spec.Includes = []string{"vpn"}
+	providerRepository.Save(partner)
+
+	whitelister := NewIngressWhitelister(ingressRepository, NewConfigMapRepositoryByNamespace())
+	whitelister.providerRepository = providerRepository
+	whitelister.configNamespace = "dmz"
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("4.4.4.4/32,10.0.0.0/24", ingress.Annotations[IngressWhitelistAnnotation], "Addresses from WhitelistProvider objects are missing")
+}
+
+func TestThatWhitelistProviderObjectsOverrideConfigMapProviders(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn,offices").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "1.2.3.4/32",
+			"vpn":     "4.4.4.4/32",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	providerRepository := repository.NewFakeWhitelistProviderRepository()
+	providerRepository.Save(BuildWhitelistProvider("namespace", "vpn", dmzv1.CIDREntry{CIDR: "8.8.8.8/32"}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.providerRepository = providerRepository
+	whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4/32,8.8.8.8/32", ingress.Annotations[IngressWhitelistAnnotation], "WhitelistProvider object should replace the ConfigMap entry")
+}
+
+func TestThatRemoteProvidersAreFetched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"egress": ["5.5.5.5", "6.6.6.0/24"]}`))
+	}))
+	defer server.Close()
+
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "corporate").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices":   "1.2.3.4/32",
+			"ci":        `{"url": "` + server.URL + `", "format": "json", "jsonPath": "{.egress}"}`,
+			"corporate": "@offices,@ci",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.remoteFetcher = remote.NewFetcher(http.DefaultClient, nil, nil)
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("1.2.3.4/32,5.5.5.5/32,6.6.6.0/24", ingress.Annotations[IngressWhitelistAnnotation], "Remote addresses are missing")
+}
+
+func TestThatItFailsWhenRemoteProvidersCantBeFetched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "offices,ci").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices": "1.2.3.4/32",
+			"ci":      `{"url": "` + server.URL + `"}`,
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.remoteFetcher = remote.NewFetcher(http.DefaultClient, nil, nil)
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation, "Nothing should be whitelisted until the remote provider is fetched")
+}
+
 func TestThatItFailsWhenNameHasWrongFormat(t *testing.T) {
 	ingressRepository := repository.NewFakeIngressRepository()
 	configMapRepository := repository.NewFakeConfigMapRepository()
@@ -182,6 +633,7 @@ func TestThatItFailsWhenNameHasWrongFormat(t *testing.T) {
 	configMapRepository.Save(configMap)
 
 	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
 
 	assert := assert.New(t)
 	assert.Error(err)
@@ -209,11 +661,12 @@ func TestThatReturnsErrorOnIngressRepositoryFailingToSaveObject(t *testing.T) {
 		ingressObj: *ingress,
 	}
 	ingressRepository.mock.On("Get", "namespace", "my-ingress")
-	ingressRepository.mock.On("Save", ingress)
+	ingressRepository.mock.On("Save", mock.AnythingOfType("*repository.IngressObject"))
 
 	err := NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
 	assert := assert.New(t)
 	assert.Error(err)
+	assert.NotContains(ingressRepository.ingressObj.Annotations, ManagedWhitelistAnnotation, "The observed Ingress object must not change when saving fails")
 }
 
 func TestThatReturnsErrorOnConfigMapRepositoryFailing(t *testing.T) {
@@ -231,29 +684,235 @@ func TestThatReturnsErrorOnConfigMapRepositoryFailing(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestThatUnchangedWhitelistsAreNotSaved(t *testing.T) {
+	ingressRepository := &IngressRepositoryCountingSaves{IngressRepository: repository.NewFakeIngressRepository()}
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "skipped/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.IngressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("skipped", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.Whitelist(ingressName)
+	whitelister.Whitelist(ingressName)
+
+	assert := assert.New(t)
+	assert.Equal(1, ingressRepository.saves, "The second reconcile has nothing to change")
+	assert.Equal(1.0, CounterValue(skippedWrites.WithLabelValues("skipped")), "Avoided writes are counted")
+}
+
+func TestThatWhitelistsAreComparedInCanonicalForm(t *testing.T) {
+	ingressRepository := &IngressRepositoryCountingSaves{IngressRepository: repository.NewFakeIngressRepository()}
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(DMZProvidersAnnotation, "vpn").
+		WithAnnotation(IngressWhitelistAnnotation, "123.1.2.3, 4.4.4.4/32").
+		WithAnnotation(ManagedWhitelistAnnotation, "4.4.4.4").
+		WithAnnotation(ManagedWhitelistTargetAnnotation, IngressWhitelistAnnotation).
+		Build()
+
+	ingressRepository.IngressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+
+	assert.Equal(t, 0, ingressRepository.saves, "The annotations only differ in their format")
+}
+
+func TestThatInvalidCidrsAreAlwaysWrittenAway(t *testing.T) {
+	ingressRepository := &IngressRepositoryCountingSaves{IngressRepository: repository.NewFakeIngressRepository()}
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(DMZProvidersAnnotation, "vpn").
+		WithAnnotation(IngressWhitelistAnnotation, "4.4.4.4/32,not-an-ip").
+		WithAnnotation(ManagedWhitelistAnnotation, "4.4.4.4/32").
+		Build()
+
+	ingressRepository.IngressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.Equal(1, ingressRepository.saves)
+	assert.Equal("4.4.4.4/32", ingress.Annotations[IngressWhitelistAnnotation])
+}
+
+func TestThatTheWhitelistIsWrittenInTheAnnotationOfTheIngressClass(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(DMZProvidersAnnotation, "vpn").
+		WithAnnotation(repository.IngressClassAnnotation, "public").
+		WithAnnotation("traefik.ingress.kubernetes.io/whitelist-source-range", "9.9.9.9/32").
+		Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.writers, _ = writer.ParseSelector("nginx", "public=traefik")
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("4.4.4.4/32, 9.9.9.9/32", ingress.Annotations["traefik.ingress.kubernetes.io/whitelist-source-range"], "Manually whitelisted IPs should be kept")
+	assert.Equal("4.4.4.4/32", ingress.Annotations[ManagedWhitelistAnnotation])
+	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation)
+	assert.NotContains(ingress.Annotations, "nginx.ingress.kubernetes.io/whitelist-source-range")
+}
+
+func TestThatIngressesWithoutAKnownClassUseTheDefaultWriter(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "vpn").Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.writers, _ = writer.ParseSelector("nginx", "public=traefik")
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("4.4.4.4/32", ingress.Annotations["nginx.ingress.kubernetes.io/whitelist-source-range"])
+}
+
+func TestThatManagedIpsAreRemovedFromTheAnnotationOfTheIngressClass(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(repository.IngressClassAnnotation, "haproxy").
+		WithAnnotation("haproxy.org/whitelist", "1.2.3.4, 123.1.2.3").
+		WithAnnotation(ManagedWhitelistAnnotation, "1.2.3.4/32").
+		WithAnnotation(ManagedWhitelistTargetAnnotation, "haproxy.org/whitelist").
+		Build()
+
+	ingressRepository.Save(ingress)
+
+	whitelister := NewIngressWhitelister(ingressRepository, NewConfigMapRepositoryByNamespace())
+	whitelister.writers, _ = writer.ParseSelector("legacy", "haproxy=haproxy")
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("123.1.2.3", ingress.Annotations["haproxy.org/whitelist"], "Only the manually whitelisted IP should be kept")
+	assert.NotContains(ingress.Annotations, ManagedWhitelistAnnotation)
+	assert.NotContains(ingress.Annotations, ManagedWhitelistTargetAnnotation)
+}
+
+func TestThatTheWhitelistIsMovedWhenTheWriterOfTheIngressChanges(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(DMZProvidersAnnotation, "vpn").
+		WithAnnotation(repository.IngressClassAnnotation, "nginx").
+		WithAnnotation(IngressWhitelistAnnotation, "1.2.3.4/32,9.9.9.9/32").
+		WithAnnotation(ManagedWhitelistAnnotation, "1.2.3.4/32").
+		Build()
+
+	ingressRepository.Save(ingress)
+	configMapRepository.Save(BuildConfigMap("namespace", map[string]string{
+		"vpn": "4.4.4.4/32",
+	}))
+
+	whitelister := NewIngressWhitelister(ingressRepository, configMapRepository)
+	whitelister.writers, _ = writer.ParseSelector("legacy", "nginx=nginx")
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("4.4.4.4/32,9.9.9.9/32", ingress.Annotations["nginx.ingress.kubernetes.io/whitelist-source-range"], "Manually whitelisted IPs should be moved along")
+	assert.Equal("4.4.4.4/32", ingress.Annotations[ManagedWhitelistAnnotation])
+	assert.Equal("nginx.ingress.kubernetes.io/whitelist-source-range", ingress.Annotations[ManagedWhitelistTargetAnnotation])
+	assert.NotContains(ingress.Annotations, IngressWhitelistAnnotation, "Managed IPs should not be left in the previous annotation")
+}
+
+func TestThatManagedIpsAreRemovedFromTheAnnotationTheyWereWrittenTo(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	ingressName := "namespace/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).
+		WithAnnotation(repository.IngressClassAnnotation, "nginx").
+		WithAnnotation("traefik.ingress.kubernetes.io/whitelist-source-range", "1.2.3.4/32, 9.9.9.9/32").
+		WithAnnotation(ManagedWhitelistAnnotation, "1.2.3.4/32").
+		WithAnnotation(ManagedWhitelistTargetAnnotation, "traefik.ingress.kubernetes.io/whitelist-source-range").
+		Build()
+
+	ingressRepository.Save(ingress)
+
+	whitelister := NewIngressWhitelister(ingressRepository, NewConfigMapRepositoryByNamespace())
+	whitelister.writers, _ = writer.ParseSelector("legacy", "nginx=nginx")
+	err := whitelister.Whitelist(ingressName)
+	ingress, _ = ingressRepository.Get(ingress.Namespace, ingress.Name)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("9.9.9.9/32", ingress.Annotations["traefik.ingress.kubernetes.io/whitelist-source-range"])
+	assert.NotContains(ingress.Annotations, "nginx.ingress.kubernetes.io/whitelist-source-range")
+	assert.NotContains(ingress.Annotations, ManagedWhitelistTargetAnnotation)
+}
+
+type IngressRepositoryCountingSaves struct {
+	repository.IngressRepository
+	saves int
+}
+
+func (m *IngressRepositoryCountingSaves) Save(ingress *repository.IngressObject, annotations ...string) (*repository.IngressObject, error) {
+	m.saves++
+	return m.IngressRepository.Save(ingress)
+}
+
 type IngressRepositoryThatFailsToGet struct {
 	mock.Mock
 }
 
-func (m *IngressRepositoryThatFailsToGet) Get(namespace string, key string) (*v1beta1.Ingress, error) {
+func (m *IngressRepositoryThatFailsToGet) Get(namespace string, key string) (*repository.IngressObject, error) {
 	m.Called(namespace, key)
 	return nil, errors.New("Failed to fetch Ingress from repository")
 }
-func (m *IngressRepositoryThatFailsToGet) Save(ingress *v1beta1.Ingress) (*v1beta1.Ingress, error) {
+func (m *IngressRepositoryThatFailsToGet) Reload(namespace string, key string) (*repository.IngressObject, error) {
+	return m.Get(namespace, key)
+}
+func (m *IngressRepositoryThatFailsToGet) Save(ingress *repository.IngressObject, annotations ...string) (*repository.IngressObject, error) {
 	m.Called(ingress)
 	return nil, nil
 }
 
 type IngressRepositoryThatFailsToSave struct {
 	mock       mock.Mock
-	ingressObj v1beta1.Ingress
+	ingressObj repository.IngressObject
 }
 
-func (m *IngressRepositoryThatFailsToSave) Get(namespace string, key string) (*v1beta1.Ingress, error) {
+func (m *IngressRepositoryThatFailsToSave) Get(namespace string, key string) (*repository.IngressObject, error) {
 	m.mock.Called(namespace, key)
 	return &m.ingressObj, nil
 }
-func (m *IngressRepositoryThatFailsToSave) Save(ingress *v1beta1.Ingress) (*v1beta1.Ingress, error) {
+func (m *IngressRepositoryThatFailsToSave) Reload(namespace string, key string) (*repository.IngressObject, error) {
+	return m.Get(namespace, key)
+}
+func (m *IngressRepositoryThatFailsToSave) Save(ingress *repository.IngressObject, annotations ...string) (*repository.IngressObject, error) {
 	m.mock.Called(ingress)
 	return nil, errors.New("Failed to save Ingress in repository")
 }
@@ -271,6 +930,50 @@ func (m *StubConfigMapRepository) Save(configMap *v1.ConfigMap) (*v1.ConfigMap,
 	return nil, nil
 }
 
+type ConfigMapRepositoryByNamespace struct {
+	configMaps map[string]v1.ConfigMap
+}
+
+func NewConfigMapRepositoryByNamespace() *ConfigMapRepositoryByNamespace {
+	return &ConfigMapRepositoryByNamespace{
+		configMaps: make(map[string]v1.ConfigMap),
+	}
+}
+
+func (m *ConfigMapRepositoryByNamespace) Get(namespace string, key string) (*v1.ConfigMap, error) {
+	configMap, ok := m.configMaps[namespace+"/"+key]
+	if !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("configmaps"), key)
+	}
+	return &configMap, nil
+}
+func (m *ConfigMapRepositoryByNamespace) Save(configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	m.configMaps[configMap.Namespace+"/"+configMap.Name] = *configMap
+	return configMap, nil
+}
+
+func BuildConfigMap(namespace string, data map[string]string) *v1.ConfigMap {
+	configMap := &v1.ConfigMap{
+		Data: data,
+	}
+	configMap.Name = DMZConfigMapName
+	configMap.Namespace = namespace
+
+	return configMap
+}
+
+func BuildWhitelistProvider(namespace string, name string, cidrs ...dmzv1.CIDREntry) *dmzv1.WhitelistProvider {
+	provider := &dmzv1.WhitelistProvider{
+		Spec: dmzv1.WhitelistProviderSpec{
+			CIDRs: cidrs,
+		},
+	}
+	provider.Name = name
+	provider.Namespace = namespace
+
+	return provider
+}
+
 func BuildIngressObject() *IngressBuilder {
 	return &IngressBuilder{
 		annotations: make(map[string]string),
@@ -294,8 +997,8 @@ func (builder *IngressBuilder) WithAnnotation(key string, value string) *Ingress
 	return builder
 }
 
-func (builder *IngressBuilder) Build() *v1beta1.Ingress {
-	ingress := &v1beta1.Ingress{}
+func (builder *IngressBuilder) Build() *repository.IngressObject {
+	ingress := &repository.IngressObject{}
 	ingress.Name = builder.ingressName
 	ingress.Annotations = builder.annotations
 
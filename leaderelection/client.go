@@ -0,0 +1,87 @@
+package leaderelection
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+)
+
+// NewClient returns a REST client for the Lease resource
+func NewClient(cfg *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	config := *cfg
+	config.GroupVersion = &SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&config)
+}
+
+// LeaseLock is a Lock backed by a Lease object of the k8s API
+type LeaseLock struct {
+	client    rest.Interface
+	namespace string
+	name      string
+}
+
+// NewLeaseLock returns a Lock using the Lease with the given name and namespace
+func NewLeaseLock(client rest.Interface, namespace string, name string) *LeaseLock {
+	return &LeaseLock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Get retrieves the Lease from the k8s API
+func (lock *LeaseLock) Get() (*Lease, error) {
+	lease := &Lease{}
+	err := lock.client.Get().
+		Namespace(lock.namespace).
+		Resource(LeaseResource).
+		Name(lock.name).
+		Do().
+		Into(lease)
+	return lease, err
+}
+
+// Create creates the Lease in the k8s API
+func (lock *LeaseLock) Create(spec LeaseSpec) (*Lease, error) {
+	lease := &Lease{Spec: spec}
+	lease.Name = lock.name
+	lease.Namespace = lock.namespace
+
+	result := &Lease{}
+	err := lock.client.Post().
+		Namespace(lock.namespace).
+		Resource(LeaseResource).
+		Body(lease).
+		Do().
+		Into(result)
+	return result, err
+}
+
+// Update stores the Lease in the k8s API
+func (lock *LeaseLock) Update(lease *Lease) (*Lease, error) {
+	result := &Lease{}
+	err := lock.client.Put().
+		Namespace(lock.namespace).
+		Resource(LeaseResource).
+		Name(lock.name).
+		Body(lease).
+		Do().
+		Into(result)
+	return result, err
+}
+
+// Describe returns the 'namespace/name' key of the Lease
+func (lock *LeaseLock) Describe() string {
+	return fmt.Sprintf("%s/%s", lock.namespace, lock.name)
+}
@@ -0,0 +1,223 @@
+package leaderelection
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Lock is where the replicas of the controller record which one of them is the leader
+type Lock interface {
+	// Get returns the Lease, or a NotFound error when it doesn't exist yet
+	Get() (*Lease, error)
+	// Create creates the Lease with the given spec
+	Create(spec LeaseSpec) (*Lease, error)
+	// Update stores the given Lease, failing with a Conflict error when it changed since it was read
+	Update(lease *Lease) (*Lease, error)
+	// Describe returns a name for the Lock, used in logs
+	Describe() string
+}
+
+// Config of a LeaderElector
+type Config struct {
+	// Lock is the Lease that replicas compete for
+	Lock Lock
+	// Identity is the unique name of this replica
+	Identity string
+	// LeaseDuration is how long standby replicas wait before taking over a Lease that wasn't renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying to renew the Lease before giving up the leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is how often replicas try to acquire or renew the Lease
+	RetryPeriod time.Duration
+	// OnStartedLeading is called in its own goroutine when this replica becomes the leader.
+	// The given channel is closed when the leadership is lost, or when the elector is stopped.
+	OnStartedLeading func(stop <-chan struct{})
+	// OnStoppedLeading is called when this replica stops being the leader
+	OnStoppedLeading func()
+}
+
+// LeaderElector makes sure only one replica of the controller is leading at any time
+type LeaderElector struct {
+	config Config
+	// observedSpec is the last spec of the Lease seen by this replica, and observedTime is when it was seen.
+	// The local clock is used to decide whether the Lease expired, so clock skew between replicas doesn't matter.
+	observedSpec LeaseSpec
+	observedTime time.Time
+}
+
+// NewLeaderElector returns a LeaderElector, after validating its config
+func NewLeaderElector(config Config) (*LeaderElector, error) {
+	if config.LeaseDuration <= config.RenewDeadline {
+		return nil, fmt.Errorf("The lease duration must be greater than the renew deadline")
+	}
+	if config.RenewDeadline <= config.RetryPeriod {
+		return nil, fmt.Errorf("The renew deadline must be greater than the retry period")
+	}
+	if config.Identity == "" {
+		return nil, fmt.Errorf("The identity can't be empty")
+	}
+	if config.Lock == nil {
+		return nil, fmt.Errorf("The lock can't be nil")
+	}
+
+	return &LeaderElector{config: config}, nil
+}
+
+// Run waits until this replica acquires the Lease, and keeps renewing it until it can't or the given channel is closed.
+// When the given channel is closed, the Lease is released once OnStartedLeading returns, so a standby replica can take over
+// right away. OnStoppedLeading is only called when the Lease was acquired.
+func (elector *LeaderElector) Run(stopCh <-chan struct{}) {
+	if !elector.acquire(stopCh) {
+		return
+	}
+
+	leadingCh := make(chan struct{})
+	leadingDone := make(chan struct{})
+	go func() {
+		defer close(leadingDone)
+		elector.config.OnStartedLeading(leadingCh)
+	}()
+	stopped := elector.renew(stopCh)
+	close(leadingCh)
+	if stopped {
+		<-leadingDone
+		elector.release()
+	}
+	elector.config.OnStoppedLeading()
+}
+
+// acquire tries to acquire the Lease every retry period, until it succeeds or the given channel is closed
+func (elector *LeaderElector) acquire(stopCh <-chan struct{}) bool {
+	glog.V(0).Infof("Trying to acquire the '%s' lease as '%s'", elector.config.Lock.Describe(), elector.config.Identity)
+	for !elector.tryAcquireOrRenew() {
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(elector.config.RetryPeriod):
+		}
+	}
+	glog.V(0).Infof("Acquired the '%s' lease as '%s'", elector.config.Lock.Describe(), elector.config.Identity)
+	return true
+}
+
+// renew renews the Lease every retry period, until it can't be renewed before the renew deadline or the given channel is closed.
+// It tells whether it stopped because the given channel was closed.
+func (elector *LeaderElector) renew(stopCh <-chan struct{}) bool {
+	for {
+		select {
+		case <-stopCh:
+			return true
+		case <-time.After(elector.config.RetryPeriod):
+		}
+
+		deadline := time.Now().Add(elector.config.RenewDeadline)
+		for !elector.tryAcquireOrRenew() {
+			if time.Now().Add(elector.config.RetryPeriod).After(deadline) {
+				glog.Errorf("Failed to renew the '%s' lease before the deadline", elector.config.Lock.Describe())
+				return false
+			}
+			select {
+			case <-stopCh:
+				return true
+			case <-time.After(elector.config.RetryPeriod):
+			}
+		}
+	}
+}
+
+// release removes this replica as holder of the Lease, so other replicas don't have to wait for it to expire
+func (elector *LeaderElector) release() {
+	lease, err := elector.config.Lock.Get()
+	if err != nil {
+		glog.Errorf("Error retrieving the '%s' lease to release it: %s", elector.config.Lock.Describe(), err.Error())
+		return
+	}
+	if holderOf(lease.Spec) != elector.config.Identity {
+		return
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = NewMicroTime(time.Now())
+	if _, err := elector.config.Lock.Update(lease); err != nil {
+		glog.Errorf("Error releasing the '%s' lease: %s", elector.config.Lock.Describe(), err.Error())
+		return
+	}
+	glog.V(0).Infof("Released the '%s' lease", elector.config.Lock.Describe())
+}
+
+// tryAcquireOrRenew takes the Lease when it doesn't exist, when it expired, or when this replica already holds it.
+// It tells whether this replica holds the Lease afterwards.
+func (elector *LeaderElector) tryAcquireOrRenew() bool {
+	now := time.Now()
+	leaseDurationSeconds := int32(elector.config.LeaseDuration / time.Second)
+
+	lease, err := elector.config.Lock.Get()
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			glog.Errorf("Error retrieving the '%s' lease: %s", elector.config.Lock.Describe(), err.Error())
+			return false
+		}
+
+		transitions := int32(0)
+		created, err := elector.config.Lock.Create(LeaseSpec{
+			HolderIdentity:       &elector.config.Identity,
+			LeaseDurationSeconds: &leaseDurationSeconds,
+			AcquireTime:          NewMicroTime(now),
+			RenewTime:            NewMicroTime(now),
+			LeaseTransitions:     &transitions,
+		})
+		if err != nil {
+			glog.Errorf("Error creating the '%s' lease: %s", elector.config.Lock.Describe(), err.Error())
+			return false
+		}
+		elector.observe(created.Spec, now)
+		return true
+	}
+
+	if !reflect.DeepEqual(lease.Spec, elector.observedSpec) {
+		elector.observe(lease.Spec, now)
+	}
+	holder := holderOf(lease.Spec)
+	if holder != "" && holder != elector.config.Identity && elector.observedTime.Add(elector.config.LeaseDuration).After(now) {
+		glog.V(1).Infof("The '%s' lease is held by '%s'", elector.config.Lock.Describe(), holder)
+		return false
+	}
+
+	if holder != elector.config.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = NewMicroTime(now)
+	}
+	lease.Spec.HolderIdentity = &elector.config.Identity
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = NewMicroTime(now)
+
+	updated, err := elector.config.Lock.Update(lease)
+	if err != nil {
+		glog.Errorf("Error updating the '%s' lease: %s", elector.config.Lock.Describe(), err.Error())
+		return false
+	}
+	elector.observe(updated.Spec, now)
+	return true
+}
+
+// observe records the last seen spec of the Lease, and when it was seen
+func (elector *LeaderElector) observe(spec LeaseSpec, now time.Time) {
+	elector.observedSpec = spec
+	elector.observedTime = now
+}
+
+// holderOf returns the identity of the replica holding the Lease, or an empty string when nobody holds it
+func holderOf(spec LeaseSpec) string {
+	if spec.HolderIdentity == nil {
+		return ""
+	}
+	return *spec.HolderIdentity
+}
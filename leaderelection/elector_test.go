@@ -0,0 +1,246 @@
+package leaderelection
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestThatTheLeaseIsCreatedWhenItDoesNotExist(t *testing.T) {
+	lock := NewFakeLock()
+	elector := NewTestLeaderElector(lock, "replica-1")
+
+	assert := assert.New(t)
+	assert.True(elector.tryAcquireOrRenew(), "Nobody holds the lease")
+	assert.Equal("replica-1", holderOf(lock.lease.Spec))
+	assert.Equal(int32(0), *lock.lease.Spec.LeaseTransitions)
+}
+
+func TestThatTheLeaseIsNotAcquiredWhileAnotherReplicaHoldsIt(t *testing.T) {
+	lock := NewFakeLock()
+	NewTestLeaderElector(lock, "replica-1").tryAcquireOrRenew()
+	elector := NewTestLeaderElector(lock, "replica-2")
+
+	assert := assert.New(t)
+	assert.False(elector.tryAcquireOrRenew(), "The lease was just renewed by another replica")
+	assert.Equal("replica-1", holderOf(lock.lease.Spec))
+}
+
+func TestThatAnExpiredLeaseIsTakenOver(t *testing.T) {
+	lock := NewFakeLock()
+	NewTestLeaderElector(lock, "replica-1").tryAcquireOrRenew()
+	elector := NewTestLeaderElector(lock, "replica-2")
+	elector.tryAcquireOrRenew()
+
+	// The first replica stopped renewing the lease
+	elector.observedTime = elector.observedTime.Add(-elector.config.LeaseDuration)
+
+	assert := assert.New(t)
+	assert.True(elector.tryAcquireOrRenew(), "The lease expired")
+	assert.Equal("replica-2", holderOf(lock.lease.Spec))
+	assert.Equal(int32(1), *lock.lease.Spec.LeaseTransitions)
+}
+
+func TestThatTheLeaderRenewsItsLease(t *testing.T) {
+	lock := NewFakeLock()
+	elector := NewTestLeaderElector(lock, "replica-1")
+	elector.tryAcquireOrRenew()
+	acquireTime := lock.lease.Spec.AcquireTime.Time
+
+	assert := assert.New(t)
+	assert.True(elector.tryAcquireOrRenew(), "The leader holds the lease")
+	assert.Equal(acquireTime, lock.lease.Spec.AcquireTime.Time, "Renewing doesn't change the acquire time")
+	assert.Equal(int32(0), *lock.lease.Spec.LeaseTransitions)
+}
+
+func TestThatOnlyOneReplicaLeadsAtATime(t *testing.T) {
+	lock := NewFakeLock()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	var mutex sync.Mutex
+	leaders := []string{}
+	for _, identity := range []string{"replica-1", "replica-2", "replica-3"} {
+		identity := identity
+		elector := NewTestLeaderElector(lock, identity)
+		elector.config.OnStartedLeading = func(stop <-chan struct{}) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			leaders = append(leaders, identity)
+		}
+		go elector.Run(stopCh)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, leaders, 1, "Only one replica should lead")
+}
+
+func TestThatLeadershipIsLostWhenTheLeaseCanNotBeRenewed(t *testing.T) {
+	lock := NewFakeLock()
+	stopped := make(chan struct{})
+	elector := NewTestLeaderElector(lock, "replica-1")
+	var leadingCh <-chan struct{}
+	started := make(chan struct{})
+	elector.config.OnStartedLeading = func(stop <-chan struct{}) {
+		leadingCh = stop
+		close(started)
+	}
+	elector.config.OnStoppedLeading = func() { close(stopped) }
+	go elector.Run(make(chan struct{}))
+	<-started
+
+	lock.Steal("replica-2")
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("The leadership should have been lost")
+	}
+	select {
+	case <-leadingCh:
+	default:
+		t.Fatal("The leading channel should be closed")
+	}
+}
+
+func TestThatTheLeaseIsReleasedWhenStoppingOnceTheLeaderFinished(t *testing.T) {
+	lock := NewFakeLock()
+	stopCh := make(chan struct{})
+	finished := false
+	elector := NewTestLeaderElector(lock, "replica-1")
+	started := make(chan struct{})
+	elector.config.OnStartedLeading = func(stop <-chan struct{}) {
+		close(started)
+		<-stop
+		// Work in progress when stopping, like a reconcile that is still writing
+		time.Sleep(time.Millisecond * 20)
+		finished = true
+	}
+	done := make(chan struct{})
+	go func() {
+		elector.Run(stopCh)
+		close(done)
+	}()
+	<-started
+
+	close(stopCh)
+	<-done
+
+	assert := assert.New(t)
+	assert.True(finished, "The leader must finish before releasing the lease")
+	assert.Equal("", holderOf(lock.lease.Spec), "Nobody should hold the lease")
+	assert.True(NewTestLeaderElector(lock, "replica-2").tryAcquireOrRenew(), "Another replica can take over right away")
+}
+
+func TestThatInvalidDurationsAreRejected(t *testing.T) {
+	_, err := NewLeaderElector(Config{
+		Lock:          NewFakeLock(),
+		Identity:      "replica-1",
+		LeaseDuration: time.Second,
+		RenewDeadline: time.Second * 2,
+		RetryPeriod:   time.Millisecond,
+	})
+
+	assert.Error(t, err)
+}
+
+func TestThatLeaseTimestampsHaveMicrosecondPrecision(t *testing.T) {
+	renewTime := time.Date(2017, time.June, 1, 10, 30, 0, 123456789, time.UTC)
+	lease := Lease{Spec: LeaseSpec{RenewTime: NewMicroTime(renewTime)}}
+
+	data, err := json.Marshal(lease.Spec)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(`{"renewTime":"2017-06-01T10:30:00.123456Z"}`, string(data))
+
+	decoded := LeaseSpec{}
+	assert.NoError(json.Unmarshal(data, &decoded))
+	assert.True(renewTime.Truncate(time.Microsecond).Equal(decoded.RenewTime.Time))
+}
+
+func NewTestLeaderElector(lock Lock, identity string) *LeaderElector {
+	elector, _ := NewLeaderElector(Config{
+		Lock:             lock,
+		Identity:         identity,
+		LeaseDuration:    time.Second * 2,
+		RenewDeadline:    time.Millisecond * 50,
+		RetryPeriod:      time.Millisecond * 10,
+		OnStartedLeading: func(stop <-chan struct{}) {},
+		OnStoppedLeading: func() {},
+	})
+	return elector
+}
+
+// FakeLock is an in memory Lock that rejects updates of outdated Lease objects, like the k8s API
+type FakeLock struct {
+	mutex sync.Mutex
+	lease *Lease
+}
+
+func NewFakeLock() *FakeLock {
+	return &FakeLock{}
+}
+
+func (lock *FakeLock) Get() (*Lease, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.lease == nil {
+		return nil, errors.NewNotFound(schema.GroupResource{Group: GroupName, Resource: LeaseResource}, "dmz-controller")
+	}
+	return copyLease(lock.lease), nil
+}
+
+func (lock *FakeLock) Create(spec LeaseSpec) (*Lease, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.lease != nil {
+		return nil, errors.NewConflict(schema.GroupResource{Group: GroupName, Resource: LeaseResource}, "dmz-controller", nil)
+	}
+	lock.lease = copyLease(&Lease{Spec: spec})
+	lock.lease.ResourceVersion = "1"
+	return copyLease(lock.lease), nil
+}
+
+func (lock *FakeLock) Update(lease *Lease) (*Lease, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.lease == nil || lock.lease.ResourceVersion != lease.ResourceVersion {
+		return nil, errors.NewConflict(schema.GroupResource{Group: GroupName, Resource: LeaseResource}, "dmz-controller", nil)
+	}
+	lock.lease = copyLease(lease)
+	lock.lease.ResourceVersion = nextVersion(lease.ResourceVersion)
+	return copyLease(lock.lease), nil
+}
+
+func (lock *FakeLock) Describe() string {
+	return "fake/dmz-controller"
+}
+
+// Steal makes another replica hold the lease, like when the leader was partitioned away
+func (lock *FakeLock) Steal(identity string) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	lock.lease.Spec.HolderIdentity = &identity
+	lock.lease.Spec.RenewTime = NewMicroTime(time.Now())
+	lock.lease.ResourceVersion = nextVersion(lock.lease.ResourceVersion)
+}
+
+func copyLease(lease *Lease) *Lease {
+	data, _ := json.Marshal(lease)
+	copied := &Lease{}
+	json.Unmarshal(data, copied)
+	return copied
+}
+
+func nextVersion(version string) string {
+	number, _ := strconv.Atoi(version)
+	return strconv.Itoa(number + 1)
+}
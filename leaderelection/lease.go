@@ -0,0 +1,107 @@
+package leaderelection
+
+import (
+	"encoding/json"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the Lease resource
+	GroupName = "coordination.k8s.io"
+
+	// LeaseResource is the plural name of the Lease resource
+	LeaseResource = "leases"
+
+	// RFC3339Micro is the format of the timestamps of a Lease
+	RFC3339Micro = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var (
+	// SchemeGroupVersion is the group version used to register the Lease objects
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
+
+	// SchemeBuilder collects the functions that add the Lease types to a scheme
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+
+	// AddToScheme adds the Lease types to the given scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+// Lease is the coordination.k8s.io/v1 object used as a lock by the replicas of the controller
+type Lease struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              LeaseSpec `json:"spec"`
+}
+
+// LeaseSpec tells which replica holds the Lease, and until when
+type LeaseSpec struct {
+	// HolderIdentity is the identity of the replica holding the Lease
+	HolderIdentity *string `json:"holderIdentity,omitempty"`
+	// LeaseDurationSeconds is how long other replicas wait before taking over the Lease, since the last renewal
+	LeaseDurationSeconds *int32 `json:"leaseDurationSeconds,omitempty"`
+	// AcquireTime is when the current holder acquired the Lease
+	AcquireTime *MicroTime `json:"acquireTime,omitempty"`
+	// RenewTime is when the current holder renewed the Lease for the last time
+	RenewTime *MicroTime `json:"renewTime,omitempty"`
+	// LeaseTransitions is the number of times the Lease changed its holder
+	LeaseTransitions *int32 `json:"leaseTransitions,omitempty"`
+}
+
+// LeaseList is a list of Lease objects
+type LeaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []Lease `json:"items"`
+}
+
+// MicroTime is a timestamp with microsecond precision, which is how the API server expects the timestamps of a Lease
+type MicroTime struct {
+	time.Time
+}
+
+// NewMicroTime returns a MicroTime for the given time
+func NewMicroTime(t time.Time) *MicroTime {
+	return &MicroTime{t}
+}
+
+// MarshalJSON writes the timestamp in the RFC3339Micro format
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(RFC3339Micro))
+}
+
+// UnmarshalJSON reads a timestamp in the RFC3339 format, with or without fractional seconds
+func (t *MicroTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.Local()
+	return nil
+}
+
+// addKnownTypes adds the Lease types to the given scheme
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&Lease{},
+		&LeaseList{},
+	)
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
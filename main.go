@@ -11,23 +11,58 @@ import (
 	"k8s.io/client-go/util/workqueue"
 
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"github.com/fiunchinho/dmz-controller/leaderelection"
+	"github.com/fiunchinho/dmz-controller/remote"
 	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/writer"
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
-// DMZConfigMapName is an internal annotation used to store addreses whitelisted by this controller
-const DMZConfigMapName = "dmz-controller"
+const (
+	// DMZConfigMapName is an internal annotation used to store addreses whitelisted by this controller
+	DMZConfigMapName = "dmz-controller"
+
+	// ProviderSourceConfigMap reads providers only from the dmz-controller ConfigMap
+	ProviderSourceConfigMap = "configmap"
+	// ProviderSourceCRD reads providers only from WhitelistProvider objects
+	ProviderSourceCRD = "crd"
+	// ProviderSourceAll reads providers from both the dmz-controller ConfigMap and WhitelistProvider objects
+	ProviderSourceAll = "all"
+)
 
 var (
+	// namespace is where the controller is running. The central dmz-controller ConfigMap is read from this namespace.
 	namespace string
+
+	// allNamespaces makes the controller whitelist Ingress objects in every namespace, instead of only its own namespace.
+	allNamespaces bool
+
+	// namespaceSelector restricts the watched namespaces to the ones matching these labels. Nil means no restriction.
+	namespaceSelector labels.Selector
+
 	// queue is a queue of resources to be processed.
 	// It performs exponential backoff rate limiting, with a minimum retry period of 5 seconds and a maximum of 1 minute.
 	queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second*15, time.Minute))
@@ -43,6 +78,18 @@ var (
 
 	// client is a Kubernetes API client for our custom resource definition type
 	client kubernetes.Interface
+
+	// ingressInformer caches the Ingress objects, decoded as repository.IngressObject no matter their API version
+	ingressInformer cache.SharedIndexInformer
+
+	// providerRepository reads WhitelistProvider objects. Nil when providers only come from ConfigMaps.
+	providerRepository repository.WhitelistProviderRepository
+
+	// policyTargetRepository reads the Service and Deployment objects that can get a NetworkPolicy. Nil when NetworkPolicies are not generated.
+	policyTargetRepository repository.PolicyTargetRepository
+
+	// serviceRepository reads the Service objects whose source ranges are whitelisted. Nil when source ranges are not managed.
+	serviceRepository repository.ServiceRepository
 )
 
 func getNamespace() string {
@@ -64,6 +111,31 @@ func main() {
 	// When running as a pod in-cluster, a kubeconfig is not needed. Instead this will make use of the service account injected into the pod.
 	// However, allow the use of a local kubeconfig as this can make local development & testing easier.
 	kubeconfig := flag.String("kubeconfig", "", "Path to a kubeconfig file")
+	flag.BoolVar(&allNamespaces, "all-namespaces", false, "Watch Ingress objects in every namespace")
+	aggregate := flag.Bool("aggregate-cidrs", false, "Merge the CIDRs coming from providers into the smallest equivalent list")
+	providerSource := flag.String("provider-source", ProviderSourceConfigMap, "Where providers are defined: 'configmap', 'crd' or 'all'")
+	remoteRefreshInterval := flag.Duration("remote-refresh-interval", time.Minute*5, "How often the addresses of remote providers are fetched again")
+	webhookAddress := flag.String("webhook-address", "", "Address where the validating admission webhook listens, like ':8443'. The webhook is disabled when empty")
+	webhookCertFile := flag.String("webhook-cert-file", "", "Path to the TLS certificate of the admission webhook")
+	webhookKeyFile := flag.String("webhook-key-file", "", "Path to the TLS private key of the admission webhook")
+	webhookBlockConfigMapDeletion := flag.Bool("webhook-block-configmap-deletion", false, "Reject deleting the dmz-controller ConfigMap while Ingress objects use its providers. The 'remove' ConfigMap deletion policy never applies then")
+	webhookWarnUnknownProviders := flag.Bool("webhook-warn-unknown-providers", false, "Allow Ingress objects using unknown providers with a warning, instead of rejecting them")
+	metricsAddress := flag.String("metrics-address", ":8080", "Address where Prometheus metrics are served on the /metrics path")
+	leaderElect := flag.Bool("leader-elect", false, "Run several replicas, where only the one holding the Lease whitelists Ingress objects")
+	leaseName := flag.String("leader-elect-lease-name", "dmz-controller", "Name of the Lease used for leader election, in the namespace of the controller")
+	leaseDuration := flag.Duration("leader-elect-lease-duration", time.Second*15, "How long standby replicas wait before taking over a Lease that wasn't renewed")
+	renewDeadline := flag.Duration("leader-elect-renew-deadline", time.Second*10, "How long the leader keeps retrying to renew its Lease before giving up the leadership")
+	retryPeriod := flag.Duration("leader-elect-retry-period", time.Second*2, "How often replicas try to acquire or renew the Lease")
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", time.Second*20, "How long reconciles in progress can take to finish after a SIGTERM or SIGINT, before exiting anyway")
+	stallTimeout := flag.Duration("stall-timeout", time.Minute*5, "How long the workers can go without progress while there is work to do, before /healthz fails")
+	workers := flag.Int("workers", 1, "Number of Ingress objects whitelisted in parallel")
+	configMapDeletionPolicy := flag.String("configmap-deletion-policy", ConfigMapDeletionPolicyKeep, "What happens to the whitelists while the central ConfigMap doesn't exist: 'keep' them as they are, or 'remove' the managed CIDRs")
+	ingressAPIVersion := flag.String("ingress-api-version", "", "API version of the watched Ingress objects: 'networking.k8s.io/v1' or 'extensions/v1beta1'. By default, the most recent one served by the cluster is used")
+	whitelistWriters := flag.String("whitelist-writers", "nginx=nginx,traefik=traefik,haproxy=haproxy", "Comma separated 'class=flavour' pairs choosing the whitelist annotation written for the Ingress objects of each ingress class. Flavours: legacy, nginx, traefik, haproxy")
+	defaultWriter := flag.String("default-whitelist-writer", "legacy", "Flavour of the whitelist annotation written for Ingress objects without a class, or of a class missing from --whitelist-writers")
+	networkPolicies := flag.Bool("network-policies", false, "Generate a NetworkPolicy for the pods of Service and Deployment objects with the '"+NetworkPolicyProvidersAnnotation+"' annotation, only allowing traffic from their providers")
+	loadBalancerSourceRanges := flag.Bool("load-balancer-source-ranges", false, "Whitelist the loadBalancerSourceRanges of LoadBalancer Service objects with the '"+DMZProvidersAnnotation+"' annotation")
+	selector := flag.String("namespace-selector", "", "Only watch Ingress objects in namespaces matching this label selector. Implies --all-namespaces")
 
 	// We log to stderr because glog will default to logging to a file. By setting this debugging is easier via `kubectl logs`
 	flag.Set("logtostderr", "true")
@@ -75,6 +147,38 @@ func main() {
 		glog.Fatalf("The NAMESPACE environment variable is not set, and the file /var/run/secrets/kubernetes.io/serviceaccount/namespace can't be read")
 	}
 
+	if *selector != "" {
+		var err error
+		namespaceSelector, err = labels.Parse(*selector)
+		if err != nil {
+			glog.Fatalf("Invalid namespace selector '%s': %s", *selector, err.Error())
+		}
+		allNamespaces = true
+	}
+
+	if *workers < 1 {
+		glog.Fatalf("The number of workers must be at least 1, got %d", *workers)
+	}
+
+	if *providerSource != ProviderSourceConfigMap && *providerSource != ProviderSourceCRD && *providerSource != ProviderSourceAll {
+		glog.Fatalf("Invalid provider source '%s'", *providerSource)
+	}
+
+	if *configMapDeletionPolicy != ConfigMapDeletionPolicyKeep && *configMapDeletionPolicy != ConfigMapDeletionPolicyRemove {
+		glog.Fatalf("Invalid ConfigMap deletion policy '%s', it must be '%s' or '%s'", *configMapDeletionPolicy, ConfigMapDeletionPolicyKeep, ConfigMapDeletionPolicyRemove)
+	}
+	if *webhookBlockConfigMapDeletion && *configMapDeletionPolicy == ConfigMapDeletionPolicyRemove {
+		glog.Warningf("The webhook rejects deleting the ConfigMap while Ingress objects use its providers, so the '%s' ConfigMap deletion policy only applies to unused ConfigMaps", ConfigMapDeletionPolicyRemove)
+	}
+
+	writerSelector, err := writer.ParseSelector(*defaultWriter, *whitelistWriters)
+	if err != nil {
+		glog.Fatalf("Invalid whitelist writers: %s", err.Error())
+	}
+
+	// Stop gracefully on SIGTERM and SIGINT, so a pod termination doesn't interrupt reconciles halfway
+	go handleSignals(*shutdownGracePeriod)
+
 	// Build the client config - optionally using a provided kubeconfig file.
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
@@ -87,60 +191,445 @@ func main() {
 		glog.Fatalf("Error creating kubernetes client: %s", err.Error())
 	}
 
+	// extensions/v1beta1 Ingress objects are no longer served by current clusters, so the API version is discovered unless it's given
+	var ingressVersion schema.GroupVersion
+	if *ingressAPIVersion != "" {
+		ingressVersion, err = repository.ParseIngressVersion(*ingressAPIVersion)
+	} else {
+		ingressVersion, err = repository.DiscoverIngressVersion(client.Discovery())
+	}
+	if err != nil {
+		glog.Fatalf("Error finding the Ingress API version: %s", err.Error())
+	}
+	glog.V(0).Infof("Watching Ingress objects of the '%s' API version", ingressVersion.String())
+
 	// We use a shared informer from the informer factory, to save calls to the API as we grow our application
 	// and so state is consistent between our control loops.
 	// We set a resync period of 30 seconds, in case any create/replace/update/delete operations are missed when watching
 	sharedFactory = informers.NewSharedInformerFactory(client, time.Second*30)
-	informer := sharedFactory.Extensions().V1beta1().Ingresses().Informer()
 	cmInformer := sharedFactory.Core().V1().ConfigMaps().Informer()
 
+	// Ingress objects are read through their own informer, since their API version is only known at runtime.
+	// They are indexed by the providers they use, so only the ones using a changed provider are queued.
+	ingressClient, err := repository.NewIngressClient(config, ingressVersion)
+	if err != nil {
+		glog.Fatalf("Error creating Ingress client: %s", err.Error())
+	}
+	ingressInformer = cache.NewSharedIndexInformer(
+		cache.NewListWatchFromClient(ingressClient, repository.IngressResource, metav1.NamespaceAll, fields.Everything()),
+		&repository.IngressObject{},
+		time.Second*30,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc, ProviderIndex: indexIngressByProvider},
+	)
+	informersSynced := []cache.InformerSynced{cmInformer.HasSynced, ingressInformer.HasSynced}
+
 	// Add a new event handler watching for changes to Ingress resources.
-	informer.AddEventHandler(
+	ingressInformer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			AddFunc: enqueue,
+			AddFunc: enqueueIngress,
 			UpdateFunc: func(old, cur interface{}) {
 				if !reflect.DeepEqual(old, cur) {
-					enqueue(cur)
+					enqueueIngress(cur)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+					deleteIngressMetrics(key)
 				}
 			},
 		},
 	)
-	// Add another handler watching for changes to an specific ConfigMap.
-	// If the ConfigMap changes, we queue all the Ingress objects.
+	// Add another handler watching for changes to an specific ConfigMap, including its creation and deletion.
+	// Only the Ingress objects using the providers that changed are queued: of every watched namespace when the central
+	// ConfigMap changes, or of the ConfigMap namespace when a per-namespace ConfigMap changes.
+	enqueueConfigMapChange := func(old, cur interface{}) {
+		if tombstone, ok := old.(cache.DeletedFinalStateUnknown); ok {
+			old = tombstone.Obj
+		}
+		oldConfigMap, _ := old.(*v1.ConfigMap)
+		curConfigMap, _ := cur.(*v1.ConfigMap)
+		configMap := curConfigMap
+		if configMap == nil {
+			configMap = oldConfigMap
+		}
+		if configMap == nil || configMap.Name != DMZConfigMapName {
+			return
+		}
+
+		if configMap.Namespace == namespace && (oldConfigMap == nil || curConfigMap == nil) {
+			// Whether any whitelist can be calculated depends on the central ConfigMap existing, so every Ingress object is queued
+			enqueueObjectsInNamespace(metav1.NamespaceAll)
+		} else {
+			enqueueObjectsUsingProviders(configMap.Namespace, getChangedProviders(configMapData(oldConfigMap), configMapData(curConfigMap)))
+		}
+		deleteProviderMetrics(getRemovedProviders(configMapData(oldConfigMap), configMapData(curConfigMap)))
+		lastConfigMapResync.WithLabelValues(configMap.Namespace).Set(float64(time.Now().Unix()))
+	}
 	cmInformer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				enqueueConfigMapChange(nil, obj)
+			},
 			UpdateFunc: func(old, cur interface{}) {
-				if cur.(*v1.ConfigMap).Name == DMZConfigMapName {
-					if !reflect.DeepEqual(old, cur) {
-						ingresses, err := sharedFactory.Extensions().V1beta1().Ingresses().Lister().Ingresses(namespace).List(labels.Everything())
-						if err != nil {
-							glog.Fatalf("Error listing ingresses to notify ConfigMap change: %s", err.Error())
-						}
-						for _, ingress := range ingresses {
-							glog.V(0).Infof("Queuing ingress '%s' object, because of a ConfigMap change", ingress.Name)
-							enqueue(ingress)
-						}
-					}
+				if !reflect.DeepEqual(old, cur) {
+					enqueueConfigMapChange(old, cur)
 				}
 			},
+			DeleteFunc: func(obj interface{}) {
+				enqueueConfigMapChange(obj, nil)
+			},
 		},
 	)
+	// When watching namespaces by label, a namespace can start matching the selector at any time.
+	if namespaceSelector != nil {
+		nsInformer := sharedFactory.Core().V1().Namespaces().Informer()
+		informersSynced = append(informersSynced, nsInformer.HasSynced)
+		nsInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(old, cur interface{}) {
+					if !reflect.DeepEqual(old.(*v1.Namespace).Labels, cur.(*v1.Namespace).Labels) {
+						enqueueObjectsInNamespace(cur.(*v1.Namespace).Name)
+					}
+				},
+			},
+		)
+	}
+
+	// WhitelistProvider objects are read through their own informer, since they are not part of the shared informer factory.
+	if *providerSource != ProviderSourceConfigMap {
+		providerClient, err := dmzv1.NewClient(config)
+		if err != nil {
+			glog.Fatalf("Error creating WhitelistProvider client: %s", err.Error())
+		}
+		providerInformer := cache.NewSharedIndexInformer(
+			cache.NewListWatchFromClient(providerClient, dmzv1.WhitelistProviderResource, metav1.NamespaceAll, fields.Everything()),
+			&dmzv1.WhitelistProvider{},
+			time.Second*30,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+		enqueueProviderChange := func(obj interface{}) {
+			if provider, ok := obj.(*dmzv1.WhitelistProvider); ok {
+				enqueueObjectsUsingProviders(provider.Namespace, []string{provider.Name})
+			}
+		}
+		providerInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: enqueueProviderChange,
+				UpdateFunc: func(old, cur interface{}) {
+					// Status updates don't change the whitelisted addresses
+					if !reflect.DeepEqual(old.(*dmzv1.WhitelistProvider).Spec, cur.(*dmzv1.WhitelistProvider).Spec) {
+						enqueueProviderChange(cur)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if provider, ok := obj.(*dmzv1.WhitelistProvider); ok {
+						deleteProviderMetrics([]string{provider.Name})
+					}
+					enqueueProviderChange(obj)
+				},
+			},
+		)
+		informersSynced = append(informersSynced, providerInformer.HasSynced)
+		providerRepository = repository.NewWhitelistProviderRepository(providerClient, providerInformer)
+		go providerInformer.Run(stopCh)
+	}
+
+	// Service objects can get a NetworkPolicy and whitelisted source ranges. They are queued when their annotations or their spec change,
+	// which includes changes made by hand to their source ranges. Status updates don't change any of them.
+	if *networkPolicies || *loadBalancerSourceRanges {
+		serviceInformer := sharedFactory.Core().V1().Services().Informer()
+		serviceInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					enqueueObject(repository.ServiceKind, obj)
+				},
+				UpdateFunc: func(old, cur interface{}) {
+					oldService, curService := old.(*v1.Service), cur.(*v1.Service)
+					if !reflect.DeepEqual(oldService.Annotations, curService.Annotations) || !reflect.DeepEqual(oldService.Spec, curService.Spec) {
+						enqueueObject(repository.ServiceKind, cur)
+					}
+				},
+			},
+		)
+		informersSynced = append(informersSynced, serviceInformer.HasSynced)
+	}
+	if *loadBalancerSourceRanges {
+		serviceRepository = repository.NewServiceRepository(client, sharedFactory)
+	}
+
+	// Service and Deployment objects with providers get a NetworkPolicy. apps/v1 Deployment objects and NetworkPolicy objects are read
+	// through their own informers, since the shared informer factory doesn't know these API versions.
+	// Deployment objects are queued when their providers or their pods change, and when someone else changes their NetworkPolicy.
+	var policyRepository repository.NetworkPolicyRepository
+	if *networkPolicies {
+		deploymentClient, err := repository.NewDeploymentClient(config)
+		if err != nil {
+			glog.Fatalf("Error creating Deployment client: %s", err.Error())
+		}
+		deploymentInformer := cache.NewSharedIndexInformer(
+			cache.NewListWatchFromClient(deploymentClient, repository.DeploymentResource, metav1.NamespaceAll, fields.Everything()),
+			&repository.DeploymentObject{},
+			time.Second*30,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+		deploymentInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					enqueueObject(repository.DeploymentKind, obj)
+				},
+				UpdateFunc: func(old, cur interface{}) {
+					// Status updates, which happen on every pod change, don't change the NetworkPolicy
+					oldDeployment, curDeployment := old.(*repository.DeploymentObject), cur.(*repository.DeploymentObject)
+					if !reflect.DeepEqual(oldDeployment.Annotations, curDeployment.Annotations) || !reflect.DeepEqual(oldDeployment.Spec, curDeployment.Spec) {
+						enqueueObject(repository.DeploymentKind, cur)
+					}
+				},
+			},
+		)
+
+		policyClient, err := networkingv1.NewClient(config)
+		if err != nil {
+			glog.Fatalf("Error creating NetworkPolicy client: %s", err.Error())
+		}
+		policyInformer := cache.NewSharedIndexInformer(
+			cache.NewListWatchFromClient(policyClient, networkingv1.NetworkPolicyResource, metav1.NamespaceAll, fields.Everything()),
+			&networkingv1.NetworkPolicy{},
+			time.Second*30,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+		policyInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(old, cur interface{}) {
+					if !reflect.DeepEqual(old.(*networkingv1.NetworkPolicy).Spec, cur.(*networkingv1.NetworkPolicy).Spec) {
+						enqueuePolicyOwner(cur)
+					}
+				},
+				DeleteFunc: enqueuePolicyOwner,
+			},
+		)
+
+		informersSynced = append(informersSynced, deploymentInformer.HasSynced, policyInformer.HasSynced)
+		policyTargetRepository = repository.NewPolicyTargetRepository(sharedFactory, deploymentInformer)
+		policyRepository = repository.NewNetworkPolicyRepository(policyClient, policyInformer)
+		go deploymentInformer.Run(stopCh)
+		go policyInformer.Run(stopCh)
+	}
+
+	// The controller is ready once the caches are populated and, when providers only come from ConfigMaps, the central ConfigMap exists
+	var centralConfigMapFound func() error
+	if *providerSource == ProviderSourceConfigMap {
+		centralConfigMapFound = func() error {
+			_, err := sharedFactory.Core().V1().ConfigMaps().Lister().ConfigMaps(namespace).Get(DMZConfigMapName)
+			return err
+		}
+	}
+	healthChecker := NewHealthChecker(centralConfigMapFound, queue.Len, *stallTimeout)
+
+	// Serve Prometheus metrics and health checks
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", healthChecker.ServeHealthz)
+		mux.HandleFunc("/readyz", healthChecker.ServeReadyz)
+		glog.V(0).Infof("Serving metrics and health checks on '%s'", *metricsAddress)
+		glog.Fatal(http.ListenAndServe(*metricsAddress, mux))
+	}()
 
 	// start the informer. This will cause it to begin receiving updates from the configured API server and firing event handlers in response.
 	sharedFactory.Start(stopCh)
+	go ingressInformer.Run(stopCh)
 	glog.V(0).Infof("Started informer factory.")
 
 	// wait for the informer cache to finish performing it's initial applyWhiteList of resources
-	if !cache.WaitForCacheSync(stopCh, cmInformer.HasSynced, informer.HasSynced) {
-		glog.Fatalf("Error waiting for informer cache: %s", err.Error())
+	if !cache.WaitForCacheSync(stopCh, informersSynced...) {
+		glog.V(0).Infof("Stopped before the informer cache was populated")
+		return
 	}
 	glog.V(0).Infof("Finished populating shared informers cache. Listening for changes...")
+	healthChecker.SetSynced()
+
+	// Events about the whitelist are recorded on the Ingress objects, so they show up in `kubectl describe ingress`
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.V(1).Infof)
+	eventBroadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: client.CoreV1().Events(metav1.NamespaceAll)})
+	recorder := eventBroadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: "dmz-controller"})
 
 	ingressWhitelister := IngressWhitelister{
-		ingressRepository:   repository.NewIngressRepository(client, sharedFactory),
-		configMapRepository: repository.NewConfigMapRepository(client, sharedFactory),
+		ingressRepository:       repository.NewIngressRepository(ingressVersion, ingressClient, ingressInformer),
+		providerRepository:      providerRepository,
+		configNamespace:         namespace,
+		aggregate:               *aggregate,
+		configMapDeletionPolicy: *configMapDeletionPolicy,
+		writers:                 writerSelector,
+		recorder:                recorder,
+	}
+	// When the addresses of a remote provider change, any Ingress object could be using it
+	ingressWhitelister.remoteFetcher = remote.NewFetcher(&http.Client{Timeout: time.Second * 30}, listRemoteSources, func() {
+		enqueueObjectsInNamespace(metav1.NamespaceAll)
+	})
+	go ingressWhitelister.remoteFetcher.Run(*remoteRefreshInterval, stopCh)
+	if *providerSource != ProviderSourceCRD {
+		ingressWhitelister.configMapRepository = repository.NewConfigMapRepository(client, sharedFactory)
+	}
+
+	// Queue keys of Service and Deployment objects carry their kind, every other key belongs to an Ingress object.
+	// A Service object can get both a NetworkPolicy and whitelisted source ranges, so both are reconciled for its key.
+	var policyGenerator *NetworkPolicyGenerator
+	if *networkPolicies {
+		policyGenerator = &NetworkPolicyGenerator{
+			whitelister:      &ingressWhitelister,
+			targetRepository: policyTargetRepository,
+			policyRepository: policyRepository,
+			recorder:         recorder,
+		}
+	}
+	var serviceWhitelister *ServiceWhitelister
+	if *loadBalancerSourceRanges {
+		serviceWhitelister = &ServiceWhitelister{
+			whitelister:       &ingressWhitelister,
+			serviceRepository: serviceRepository,
+		}
+	}
+	reconcileKey := func(key string) error {
+		if isIngressKey(key) {
+			return ingressWhitelister.Whitelist(key)
+		}
+		if kind, _, _, err := splitObjectKey(key); err == nil && kind == repository.ServiceKind && serviceWhitelister != nil {
+			if err := serviceWhitelister.Whitelist(key); err != nil {
+				return err
+			}
+		}
+		if policyGenerator != nil {
+			return policyGenerator.Sync(key)
+		}
+		return nil
+	}
+
+	// Serve the validating admission webhook
+	if *webhookAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/validate", &AdmissionWebhook{
+			whitelister:            &ingressWhitelister,
+			listIngresses:          listWatchedIngresses,
+			warnUnknownProviders:   *webhookWarnUnknownProviders,
+			blockConfigMapDeletion: *webhookBlockConfigMapDeletion,
+		})
+		go func() {
+			glog.V(0).Infof("Serving admission webhook on '%s'", *webhookAddress)
+			glog.Fatal(http.ListenAndServeTLS(*webhookAddress, *webhookCertFile, *webhookKeyFile, mux))
+		}()
+	}
+
+	// reconcile whitelists Ingress objects, and keeps the status of WhitelistProvider objects up to date with the Ingress objects using them
+	reconcile := func(stop <-chan struct{}) {
+		if providerRepository != nil {
+			go wait.Until(func() {
+				ingresses, err := listWatchedIngresses()
+				if err != nil {
+					runtime.HandleError(fmt.Errorf("Error listing ingresses to update WhitelistProvider status: %s", err.Error()))
+					return
+				}
+				if err := ingressWhitelister.SyncProviderStatus(ingresses); err != nil {
+					runtime.HandleError(fmt.Errorf("Error updating WhitelistProvider status: %s", err.Error()))
+				}
+			}, time.Minute, stop)
+		}
+		runWorkers(*workers, reconcileKey, healthChecker, stop)
+	}
+
+	if !*leaderElect {
+		reconcile(stopCh)
+		glog.V(0).Infof("Shutdown complete")
+		glog.Flush()
+		return
+	}
+
+	// Every replica keeps its informer caches warm, but only the one holding the Lease whitelists Ingress objects
+	leaseClient, err := leaderelection.NewClient(config)
+	if err != nil {
+		glog.Fatalf("Error creating Lease client: %s", err.Error())
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Error getting the hostname to use as leader election identity: %s", err.Error())
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.Config{
+		Lock:          leaderelection.NewLeaseLock(leaseClient, namespace, *leaseName),
+		Identity:      identity,
+		LeaseDuration: *leaseDuration,
+		RenewDeadline: *renewDeadline,
+		RetryPeriod:   *retryPeriod,
+		OnStartedLeading: func(leading <-chan struct{}) {
+			// The previous leader may have stopped halfway, so every watched Ingress object is reconciled again
+			enqueueObjectsInNamespace(metav1.NamespaceAll)
+			reconcile(leading)
+		},
+		OnStoppedLeading: func() {
+			select {
+			case <-stopCh:
+				// Shutting down, and the Lease was already released
+			default:
+				// Exiting drops the queue and any reconcile in progress, so nothing is written once another replica leads
+				glog.Fatalf("Lost the '%s/%s' lease, exiting", namespace, *leaseName)
+			}
+		},
+	})
+	if err != nil {
+		glog.Fatalf("Invalid leader election settings: %s", err.Error())
+	}
+	elector.Run(stopCh)
+	glog.V(0).Infof("Shutdown complete")
+	glog.Flush()
+}
+
+// handleSignals closes stopCh on the first SIGTERM or SIGINT, which stops the informers, the workers and any other loop.
+// The process exits with an error if the reconciles in progress don't finish within the grace period, or on a second signal.
+func handleSignals(gracePeriod time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	received := <-signals
+	glog.V(0).Infof("Received %s, shutting down", received)
+	close(stopCh)
+
+	select {
+	case received = <-signals:
+		glog.Errorf("Received %s while shutting down, exiting immediately", received)
+	case <-time.After(gracePeriod):
+		glog.Errorf("Reconciles in progress didn't finish within the %s grace period, exiting", gracePeriod)
+	}
+	glog.Flush()
+	os.Exit(1)
+}
+
+// runWorkers starts the given number of workers reading objects off the queue and reconciling them, until the given channel is closed.
+// The queue never hands the same key to two workers at once, so each object is reconciled by one worker at a time.
+// Once the channel is closed, the queue is shut down and runWorkers returns when the reconciles in progress finish.
+func runWorkers(workers int, reconcile func(key string) error, healthChecker *HealthChecker, stop <-chan struct{}) {
+	healthChecker.WorkersStarted()
+	defer healthChecker.WorkersStopped()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWorker(reconcile, healthChecker, stop)
+		}()
 	}
+	glog.V(0).Infof("Started %d workers", workers)
+
+	<-stop
+	queue.ShutDown()
+	wg.Wait()
+	glog.V(0).Infof("All workers finished")
+}
 
+// runWorker reads objects off the queue and reconciles them, until the queue is shut down or the given channel is closed
+func runWorker(reconcile func(key string) error, healthChecker *HealthChecker, stop <-chan struct{}) {
 	// Start reading objects off the queue
 	for {
 		// Read a message off the queue
@@ -149,16 +638,25 @@ func main() {
 
 		// If the queue has been shut down, we should exit the work queue here.
 		if shutdown {
-			stopCh <- struct{}{}
 			return
 		}
 
+		// A shut down queue keeps handing out the keys it still has, but they are left for whoever reconciles next
+		select {
+		case <-stop:
+			queue.Done(key)
+			return
+		default:
+		}
+
 		// Convert the queue item into a string. If it's not a string, we'll simply discard it as invalid data and log a message.
 		var strKey string
 		var ok bool
 		if strKey, ok = key.(string); !ok {
 			runtime.HandleError(fmt.Errorf("Key in queue should be of type string but got %T. discarding", key))
-			return
+			queue.Forget(key)
+			queue.Done(key)
+			continue
 		}
 
 		// We define a function here to process a queue item, so that we can use 'defer' to make sure the message is marked as Done on the queue.
@@ -166,11 +664,22 @@ func main() {
 		func(key string) {
 			// Done marks item as done processing, and if it has been marked as dirty again while it was being processed, it will be re-added to the queue for re-processing.
 			defer queue.Done(key)
+			healthChecker.ReconcileStarted()
+			defer healthChecker.ReconcileFinished()
 
-			if err = ingressWhitelister.Whitelist(key); err != nil {
-				runtime.HandleError(fmt.Errorf("Error whitelisting '%s': %s", key, err.Error()))
+			objectNamespace, _, _ := cache.SplitMetaNamespaceKey(key)
+			kind := reconcileKind(key)
+			start := time.Now()
+			err := reconcile(key)
+			reconcileDuration.WithLabelValues(objectNamespace, kind).Observe(time.Since(start).Seconds())
+			if err != nil {
+				reconcileTotal.WithLabelValues(objectNamespace, kind, ReconcileResultError).Inc()
+				workqueueRetries.Inc()
+				runtime.HandleError(fmt.Errorf("Error reconciling '%s': %s", key, err.Error()))
+				queue.AddRateLimited(key)
 				return
 			}
+			reconcileTotal.WithLabelValues(objectNamespace, kind, ReconcileResultSuccess).Inc()
 
 			// As we managed to process this successfully, we can forget it from the work queue altogether.
 			// Forget indicates that an item is finished being retried. Doesn't matter whether its for perm failing
@@ -182,6 +691,210 @@ func main() {
 	}
 }
 
+// watchesNamespace tells whether Ingress objects living in the given namespace must be whitelisted by this controller
+func watchesNamespace(ns string) bool {
+	if namespaceSelector != nil {
+		namespaceObj, err := sharedFactory.Core().V1().Namespaces().Lister().Get(ns)
+		if err != nil {
+			return false
+		}
+		return namespaceSelector.Matches(labels.Set(namespaceObj.Labels))
+	}
+
+	return allNamespaces || ns == namespace
+}
+
+// listWatchedIngresses returns the Ingress objects of every watched namespace
+func listWatchedIngresses() ([]*repository.IngressObject, error) {
+	watchedIngresses := []*repository.IngressObject{}
+	for _, obj := range ingressInformer.GetIndexer().List() {
+		ingress := obj.(*repository.IngressObject)
+		if watchesNamespace(ingress.Namespace) {
+			watchedIngresses = append(watchedIngresses, ingress)
+		}
+	}
+	return watchedIngresses, nil
+}
+
+// enqueueIngress will add an Ingress object into the workqueue, as long as it lives in a watched namespace.
+func enqueueIngress(obj interface{}) {
+	if ingress, ok := obj.(*repository.IngressObject); ok && !watchesNamespace(ingress.Namespace) {
+		return
+	}
+	enqueue(obj)
+}
+
+// enqueueObjectsUsingProviders will add the watched objects using any of the given providers from a namespace into the workqueue,
+// including the ones using composite providers that reference them. Providers in the central namespace can be used by every watched object.
+func enqueueObjectsUsingProviders(ns string, changed []string) {
+	if len(changed) == 0 {
+		return
+	}
+	if ns == namespace {
+		ns = metav1.NamespaceAll
+	}
+
+	dependent := getDependentProviders(changed, listProviderDefinitions()...)
+	indexer := ingressInformer.GetIndexer()
+	for _, provider := range dependent {
+		objs, err := indexer.ByIndex(ProviderIndex, provider)
+		if err != nil {
+			glog.Fatalf("Error listing ingresses using provider '%s': %s", provider, err.Error())
+		}
+		for _, obj := range objs {
+			ingress := obj.(*repository.IngressObject)
+			if (ns == metav1.NamespaceAll || ingress.Namespace == ns) && watchesNamespace(ingress.Namespace) {
+				glog.V(0).Infof("Queuing ingress '%s/%s' object, because provider '%s' changed", ingress.Namespace, ingress.Name, provider)
+				enqueue(ingress)
+			}
+		}
+	}
+	enqueuePolicyTargets(ns, dependent)
+	enqueueServices(ns, dependent)
+}
+
+// configMapData returns the data of a ConfigMap, which is empty when the ConfigMap doesn't exist
+func configMapData(configMap *v1.ConfigMap) map[string]string {
+	if configMap == nil {
+		return map[string]string{}
+	}
+	return configMap.Data
+}
+
+// listProviderDefinitions returns the providers defined in every namespace, by the ConfigMaps and the WhitelistProvider objects.
+// They are used to find the composite providers referencing a changed provider.
+func listProviderDefinitions() []map[string]string {
+	definitions := []map[string]string{}
+
+	configMaps, err := sharedFactory.Core().V1().ConfigMaps().Lister().List(labels.Everything())
+	if err != nil {
+		glog.Fatalf("Error listing ConfigMaps to find composite providers: %s", err.Error())
+	}
+	for _, configMap := range configMaps {
+		if configMap.Name == DMZConfigMapName {
+			definitions = append(definitions, configMap.Data)
+		}
+	}
+
+	if providerRepository != nil {
+		whitelistProviders, err := providerRepository.List(metav1.NamespaceAll)
+		if err != nil {
+			glog.Fatalf("Error listing WhitelistProvider objects to find composite providers: %s", err.Error())
+		}
+		for _, provider := range whitelistProviders {
+			definitions = append(definitions, map[string]string{provider.Name: getProviderAddresses(provider)})
+		}
+	}
+
+	return definitions
+}
+
+// listRemoteSources returns the remote Sources defined by the providers of every namespace, which have to keep being refreshed
+func listRemoteSources() []*remote.Source {
+	sources := []*remote.Source{}
+	for _, definitions := range listProviderDefinitions() {
+		for _, value := range definitions {
+			if !remote.IsSource(value) {
+				continue
+			}
+			if source, err := remote.ParseSource(value); err == nil {
+				sources = append(sources, source)
+			}
+		}
+	}
+	return sources
+}
+
+// enqueueObjectsInNamespace will add all the watched objects of a namespace into the workqueue.
+// Passing metav1.NamespaceAll queues the watched objects of every namespace.
+func enqueueObjectsInNamespace(ns string) {
+	objs := ingressInformer.GetIndexer().List()
+	if ns != metav1.NamespaceAll {
+		var err error
+		if objs, err = ingressInformer.GetIndexer().ByIndex(cache.NamespaceIndex, ns); err != nil {
+			glog.Fatalf("Error listing ingresses to notify ConfigMap change: %s", err.Error())
+		}
+	}
+	for _, obj := range objs {
+		ingress := obj.(*repository.IngressObject)
+		if watchesNamespace(ingress.Namespace) {
+			glog.V(0).Infof("Queuing ingress '%s/%s' object, because of a ConfigMap change", ingress.Namespace, ingress.Name)
+			enqueue(ingress)
+		}
+	}
+	enqueuePolicyTargets(ns, nil)
+	enqueueServices(ns, nil)
+}
+
+// enqueuePolicyTargets will add the watched Service and Deployment objects of a namespace with NetworkPolicy providers into the workqueue.
+// When providers are given, only the objects using any of them are queued. Nothing is queued when NetworkPolicies are not generated.
+func enqueuePolicyTargets(ns string, providers []string) {
+	if policyTargetRepository == nil {
+		return
+	}
+	targets, err := policyTargetRepository.List(ns)
+	if err != nil {
+		glog.Fatalf("Error listing Service and Deployment objects to notify provider change: %s", err.Error())
+	}
+
+	for _, target := range targets {
+		annotation, ok := target.Annotations[NetworkPolicyProvidersAnnotation]
+		if !ok || !watchesNamespace(target.Namespace) || (providers != nil && !usesAnyProvider(annotation, providers)) {
+			continue
+		}
+		glog.V(0).Infof("Queuing %s '%s/%s' object, because its providers changed", target.Kind, target.Namespace, target.Name)
+		queue.Add(objectKey(target.Kind, target.Namespace, target.Name))
+	}
+}
+
+// enqueueServices will add the watched Service objects of a namespace with providers into the workqueue, to whitelist their source ranges.
+// When providers are given, only the objects using any of them are queued. Nothing is queued when source ranges are not managed.
+func enqueueServices(ns string, providers []string) {
+	if serviceRepository == nil {
+		return
+	}
+	services, err := serviceRepository.List(ns)
+	if err != nil {
+		glog.Fatalf("Error listing Service objects to notify provider change: %s", err.Error())
+	}
+
+	for _, service := range services {
+		annotation, ok := service.Annotations[DMZProvidersAnnotation]
+		if !ok || !watchesNamespace(service.Namespace) || (providers != nil && !usesAnyProvider(annotation, providers)) {
+			continue
+		}
+		glog.V(0).Infof("Queuing Service '%s/%s' object, because its providers changed", service.Namespace, service.Name)
+		queue.Add(objectKey(repository.ServiceKind, service.Namespace, service.Name))
+	}
+}
+
+// enqueueObject will add a Service or Deployment object of the given kind into the workqueue, as long as it lives in a watched namespace
+func enqueueObject(kind string, obj interface{}) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error obtaining metadata of %s being enqueued: %s", kind, err.Error()))
+		return
+	}
+	if watchesNamespace(object.GetNamespace()) {
+		queue.Add(objectKey(kind, object.GetNamespace(), object.GetName()))
+	}
+}
+
+// enqueuePolicyOwner will add the Service or Deployment object owning a generated NetworkPolicy into the workqueue,
+// so changes made to the NetworkPolicy by someone else are undone
+func enqueuePolicyOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	policy, ok := obj.(*networkingv1.NetworkPolicy)
+	if !ok || policy.Labels[ManagedByLabel] != DMZConfigMapName {
+		return
+	}
+	if owner := getControllerOf(policy); owner != nil && (owner.Kind == repository.ServiceKind || owner.Kind == repository.DeploymentKind) {
+		enqueueObject(owner.Kind, &metav1.ObjectMeta{Namespace: policy.Namespace, Name: owner.Name})
+	}
+}
+
 // enqueue will add an object 'obj' into the workqueue. The object being added must be of type metav1.Object, metav1.ObjectAccessor or cache.ExplicitKey.
 func enqueue(obj interface{}) {
 	// DeletionHandlingMetaNamespaceKeyFunc will convert an object into a 'namespace/name' string.
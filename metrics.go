@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ReconcileResultSuccess labels reconciles that finished successfully
+	ReconcileResultSuccess = "success"
+	// ReconcileResultError labels reconciles that failed and will be retried
+	ReconcileResultError = "error"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dmz_controller",
+			Name:      "reconcile_total",
+			Help:      "Number of reconciles of Ingress, Service and Deployment objects, by namespace, kind and result.",
+		},
+		[]string{"namespace", "kind", "result"},
+	)
+
+	reconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "dmz_controller",
+			Name:      "reconcile_duration_seconds",
+			Help:      "Time spent reconciling an Ingress, Service or Deployment object, by namespace and kind.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"namespace", "kind"},
+	)
+
+	workqueueRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dmz_controller",
+			Name:      "workqueue_retries_total",
+			Help:      "Number of keys requeued because their reconcile failed.",
+		},
+	)
+
+	managedCidrs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dmz_controller",
+			Name:      "managed_cidrs",
+			Help:      "Number of CIDRs managed by the controller in each Ingress.",
+		},
+		[]string{"namespace", "ingress"},
+	)
+
+	providerCidrs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dmz_controller",
+			Name:      "provider_cidrs",
+			Help:      "Number of CIDRs of each provider, as seen by Ingress objects in a namespace.",
+		},
+		[]string{"namespace", "provider"},
+	)
+
+	skippedWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dmz_controller",
+			Name:      "skipped_writes_total",
+			Help:      "Number of Ingress writes avoided because the whitelist didn't change, by namespace.",
+		},
+		[]string{"namespace"},
+	)
+
+	lastConfigMapResync = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dmz_controller",
+			Name:      "last_configmap_resync_timestamp_seconds",
+			Help:      "Unix time of the last time a change to the dmz-controller ConfigMap of a namespace queued its Ingress objects.",
+		},
+		[]string{"namespace"},
+	)
+
+	// recordedProviders keeps the names of the providers with a provider_cidrs series, by namespace, so the series can be
+	// removed once the provider is not resolved anymore
+	recordedProviders = struct {
+		sync.Mutex
+		byNamespace map[string]map[string]bool
+	}{byNamespace: make(map[string]map[string]bool)}
+)
+
+func init() {
+	prometheus.MustRegister(reconcileTotal, reconcileDuration, workqueueRetries, managedCidrs, providerCidrs, skippedWrites, lastConfigMapResync)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "dmz_controller",
+			Name:      "workqueue_depth",
+			Help:      "Number of Ingress, Service and Deployment keys waiting to be reconciled.",
+		},
+		func() float64 {
+			return float64(queue.Len())
+		},
+	))
+}
+
+// reconcileKind returns the kind of the object with the given queue key, to label the reconcile metrics
+func reconcileKind(key string) string {
+	if isIngressKey(key) {
+		return "Ingress"
+	}
+	kind, _, _, err := splitObjectKey(key)
+	if err != nil {
+		return "Unknown"
+	}
+	return kind
+}
+
+// recordProviderMetrics records the number of CIDRs of the providers used by a providers annotation.
+// The series of the used providers that can't be resolved anymore are removed.
+func recordProviderMetrics(namespace string, annotation string, providers map[string]string) {
+	recordedProviders.Lock()
+	defer recordedProviders.Unlock()
+
+	recorded, ok := recordedProviders.byNamespace[namespace]
+	if !ok {
+		recorded = make(map[string]bool)
+		recordedProviders.byNamespace[namespace] = recorded
+	}
+	for _, name := range getReferencedProviders(annotation, providers) {
+		if ips, _, err := resolveProvider(name, providers, []string{}); err == nil {
+			providerCidrs.WithLabelValues(namespace, name).Set(float64(len(ips.Ips)))
+			recorded[name] = true
+		} else if recorded[name] {
+			providerCidrs.DeleteLabelValues(namespace, name)
+			delete(recorded, name)
+		}
+	}
+	for _, name := range getUnknownProviders(annotation, providers) {
+		if recorded[name] {
+			providerCidrs.DeleteLabelValues(namespace, name)
+			delete(recorded, name)
+		}
+	}
+}
+
+// deleteProviderMetrics removes the series of the given deleted providers in every namespace.
+// The objects using them are queued again, so the series come back when the providers are still defined somewhere else.
+func deleteProviderMetrics(names []string) {
+	recordedProviders.Lock()
+	defer recordedProviders.Unlock()
+
+	for namespace, recorded := range recordedProviders.byNamespace {
+		for _, name := range names {
+			if recorded[name] {
+				providerCidrs.DeleteLabelValues(namespace, name)
+				delete(recorded, name)
+			}
+		}
+	}
+}
+
+// deleteIngressMetrics removes the series of a deleted Ingress object with the given 'namespace/name' key, which would be exported forever otherwise
+func deleteIngressMetrics(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	managedCidrs.DeleteLabelValues(namespace, name)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestThatWhitelistingRecordsCidrMetrics(t *testing.T) {
+	ingressRepository := repository.NewFakeIngressRepository()
+	configMapRepository := repository.NewFakeConfigMapRepository()
+	ingressName := "metrics/my-ingress"
+	ingress := BuildIngressObject().Named(ingressName).WithAnnotation(DMZProvidersAnnotation, "corporate").Build()
+
+	ingressRepository.Save(ingress)
+
+	configMap := &v1.ConfigMap{
+		Data: map[string]string{
+			"offices":   "1.2.3.4/32,1.2.3.5/32",
+			"vpn":       "4.4.4.4/32",
+			"corporate": "@offices,@vpn",
+		},
+	}
+	configMap.Name = DMZConfigMapName
+	configMapRepository.Save(configMap)
+
+	NewIngressWhitelister(ingressRepository, configMapRepository).Whitelist(ingressName)
+
+	assert := assert.New(t)
+	assert.Equal(3.0, GaugeValue(managedCidrs.WithLabelValues("metrics", "my-ingress")), "Managed CIDRs of the Ingress")
+	assert.Equal(3.0, GaugeValue(providerCidrs.WithLabelValues("metrics", "corporate")), "CIDRs of the composite provider")
+	assert.Equal(2.0, GaugeValue(providerCidrs.WithLabelValues("metrics", "offices")), "CIDRs of the included provider")
+}
+
+func TestThatMetricsOfDeletedIngressesAreRemoved(t *testing.T) {
+	managedCidrs.WithLabelValues("metrics", "deleted").Set(2)
+
+	deleteIngressMetrics("metrics/deleted")
+
+	assert.False(t, managedCidrs.DeleteLabelValues("metrics", "deleted"), "The series of the deleted Ingress must be removed")
+}
+
+func TestThatMetricsOfProvidersThatAreNotResolvedAnymoreAreRemoved(t *testing.T) {
+	providers := map[string]string{"offices": "1.2.3.4/32,1.2.3.5/32", "vpn": "4.4.4.4/32"}
+	recordProviderMetrics("gone", "offices,vpn", providers)
+
+	delete(providers, "vpn")
+	recordProviderMetrics("gone", "offices,vpn", providers)
+
+	assert := assert.New(t)
+	assert.False(providerCidrs.DeleteLabelValues("gone", "vpn"), "The series of a provider that doesn't exist anymore must be removed")
+	assert.Equal(2.0, GaugeValue(providerCidrs.WithLabelValues("gone", "offices")), "CIDRs of the provider that still exists")
+}
+
+func TestThatMetricsOfDeletedProvidersAreRemovedInEveryNamespace(t *testing.T) {
+	providers := map[string]string{"offices": "1.2.3.4/32"}
+	recordProviderMetrics("first", "offices", providers)
+	recordProviderMetrics("second", "offices", providers)
+
+	deleteProviderMetrics([]string{"offices"})
+
+	assert := assert.New(t)
+	assert.False(providerCidrs.DeleteLabelValues("first", "offices"), "The series of the deleted provider must be removed")
+	assert.False(providerCidrs.DeleteLabelValues("second", "offices"), "The series of the deleted provider must be removed")
+}
+
+func TestThatReconcilesAreLabelledWithTheKindOfTheObject(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("Ingress", reconcileKind("team/web"))
+	assert.Equal(repository.ServiceKind, reconcileKind(objectKey(repository.ServiceKind, "team", "api")))
+	assert.Equal(repository.DeploymentKind, reconcileKind(objectKey(repository.DeploymentKind, "team", "worker")))
+}
+
+func GaugeValue(gauge prometheus.Gauge) float64 {
+	metric := &dto.Metric{}
+	gauge.Write(metric)
+	return metric.GetGauge().GetValue()
+}
+
+func CounterValue(counter prometheus.Counter) float64 {
+	metric := &dto.Metric{}
+	counter.Write(metric)
+	return metric.GetCounter().GetValue()
+}
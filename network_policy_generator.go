@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// NetworkPolicyProvidersAnnotation is the Service and Deployment annotation making this controller generate a NetworkPolicy for their pods
+	NetworkPolicyProvidersAnnotation = "armesto.net/network-policy-providers"
+
+	// NetworkPolicyPrefix is the prefix of the name of the generated NetworkPolicy objects, followed by the kind and name of their owner
+	NetworkPolicyPrefix = "dmz-"
+
+	// ManagedByLabel marks the NetworkPolicy objects generated by this controller
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// NetworkPolicyGenerator keeps a NetworkPolicy for each Service or Deployment object with providers, which only allows
+// the traffic coming from the addresses of those providers to reach their pods.
+// The NetworkPolicy is owned by the Service or Deployment object, so it's garbage collected along with it.
+type NetworkPolicyGenerator struct {
+	// whitelister resolves the providers, the same way it does for Ingress objects
+	whitelister      *IngressWhitelister
+	targetRepository repository.PolicyTargetRepository
+	policyRepository repository.NetworkPolicyRepository
+	// recorder records Events on the Service and Deployment objects about their NetworkPolicy. When nil, no Events are recorded.
+	recorder record.EventRecorder
+}
+
+// Sync creates, updates or deletes the NetworkPolicy of the Service or Deployment object with the given queue key
+func (generator *NetworkPolicyGenerator) Sync(key string) error {
+	kind, namespace, name, err := splitObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	target, err := generator.targetRepository.Get(kind, namespace, name)
+	if errors.IsNotFound(err) {
+		// The garbage collector deletes the NetworkPolicy of deleted objects
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	glog.V(0).Infof("Got '%s/%s' %s object from cache.", namespace, name, kind)
+
+	policyName := getNetworkPolicyName(target)
+	current, err := generator.policyRepository.Get(namespace, policyName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	provider, ok := target.Annotations[NetworkPolicyProvidersAnnotation]
+
+	// NetworkPolicy objects created by someone else are never changed nor deleted
+	if current != nil && !isControlledBy(current, target) {
+		if !ok {
+			return nil
+		}
+		err := fmt.Errorf("The '%s/%s' NetworkPolicy already exists, and it's not owned by the %s", namespace, policyName, kind)
+		generator.recordFailure(target, err)
+		return err
+	}
+
+	if !ok {
+		return generator.deletePolicy(target, current, "Providers annotation was removed")
+	}
+
+	providers, err := generator.whitelister.getProviders(namespace)
+	if errors.IsNotFound(err) {
+		return generator.whitelister.applyConfigMapDeletionPolicy(target, kind, "NetworkPolicy", func(reason string) error {
+			return generator.deletePolicy(target, current, reason)
+		})
+	}
+	if err != nil {
+		generator.recordFailure(target, err)
+		return err
+	}
+
+	if err := generator.whitelister.resolveRemoteProviders(provider, providers); err != nil {
+		generator.recordFailure(target, err)
+		return err
+	}
+	allowed, err := getWhitelistFromProvider(provider, providers)
+	if err != nil {
+		generator.recordFailure(target, err)
+		return err
+	}
+	// The API server rejects ipBlock CIDRs that are not written with their network address
+	allowed.Normalise()
+	if generator.whitelister.aggregate {
+		allowed.Aggregate()
+	}
+
+	// An empty pod selector selects every pod of the namespace, which is never what the Service or Deployment meant
+	if len(target.PodSelector.MatchLabels) == 0 && len(target.PodSelector.MatchExpressions) == 0 {
+		err := fmt.Errorf("The %s doesn't select any pods", kind)
+		generator.recordFailure(target, err)
+		return generator.deletePolicy(target, current, err.Error())
+	}
+
+	desired := newNetworkPolicy(target, policyName, allowed)
+	if current != nil {
+		if reflect.DeepEqual(current.Spec, desired.Spec) {
+			glog.V(1).Infof("NetworkPolicy '%s/%s' is up to date, skipping the write", namespace, policyName)
+			skippedWrites.WithLabelValues(namespace).Inc()
+			return nil
+		}
+		desired = withSpecOf(current, desired)
+	}
+
+	if _, err := generator.policyRepository.Save(desired); err != nil {
+		generator.event(target, v1.EventTypeWarning, EventReasonSaveFailed, "Error saving the '%s' NetworkPolicy: %s", policyName, err.Error())
+		return err
+	}
+	glog.V(0).Infof("Saved NetworkPolicy '%s/%s' allowing %s IPs: %s", namespace, policyName, provider, allowed.ToString())
+	generator.event(target, v1.EventTypeNormal, EventReasonNetworkPolicyUpdated, "NetworkPolicy '%s' of providers '%s' allows: %s", policyName, provider, allowed.ToString())
+
+	return nil
+}
+
+// deletePolicy deletes the NetworkPolicy owned by a Service or Deployment object, because of the given reason. Nothing happens when it's nil.
+func (generator *NetworkPolicyGenerator) deletePolicy(target *repository.PolicyTarget, current *networkingv1.NetworkPolicy, reason string) error {
+	if current == nil {
+		return nil
+	}
+
+	err := generator.policyRepository.Delete(current.Namespace, current.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		generator.event(target, v1.EventTypeWarning, EventReasonSaveFailed, "Error deleting the '%s' NetworkPolicy: %s", current.Name, err.Error())
+		return err
+	}
+	glog.V(0).Infof("%s for %s '%s/%s'. Deleted NetworkPolicy '%s'", reason, target.Kind, target.Namespace, target.Name, current.Name)
+	generator.event(target, v1.EventTypeNormal, EventReasonNetworkPolicyRemoved, "%s: deleted the '%s' NetworkPolicy", reason, current.Name)
+
+	return nil
+}
+
+// event records an Event on the given object. Nothing is recorded when the generator has no EventRecorder.
+func (generator *NetworkPolicyGenerator) event(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if generator.recorder == nil {
+		return
+	}
+	generator.recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// recordFailure records a Warning Event for an error that prevented generating the NetworkPolicy of a Service or Deployment object
+func (generator *NetworkPolicyGenerator) recordFailure(target *repository.PolicyTarget, err error) {
+	generator.event(target, v1.EventTypeWarning, EventReasonNetworkPolicyFailed, "Error generating the NetworkPolicy: %s", err.Error())
+}
+
+// newNetworkPolicy returns the NetworkPolicy owned by a Service or Deployment object, allowing the traffic coming from the given addresses
+// to reach its pods on any port. Without addresses there are no rules, so no traffic is allowed: a rule without peers would allow any source.
+func newNetworkPolicy(target *repository.PolicyTarget, name string, allowed *whitelist.Whitelist) *networkingv1.NetworkPolicy {
+	controller := true
+	policy := &networkingv1.NetworkPolicy{
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: target.PodSelector,
+			PolicyTypes: []string{networkingv1.PolicyTypeIngress},
+		},
+	}
+	policy.APIVersion = networkingv1.SchemeGroupVersion.String()
+	policy.Kind = "NetworkPolicy"
+	policy.Name = name
+	policy.Namespace = target.Namespace
+	policy.Labels = map[string]string{ManagedByLabel: DMZConfigMapName}
+	policy.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: target.APIVersion,
+		Kind:       target.Kind,
+		Name:       target.Name,
+		UID:        target.UID,
+		Controller: &controller,
+	}}
+
+	if len(allowed.Ips) > 0 {
+		rule := networkingv1.NetworkPolicyIngressRule{}
+		for _, cidr := range allowed.Ips {
+			rule.From = append(rule.From, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		policy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{rule}
+	}
+
+	return policy
+}
+
+// withSpecOf returns a copy of the current NetworkPolicy with the spec and the labels of the desired one,
+// so the labels and annotations other tools put on it are kept, and the update applies to the current version
+func withSpecOf(current *networkingv1.NetworkPolicy, desired *networkingv1.NetworkPolicy) *networkingv1.NetworkPolicy {
+	merged := *current
+	merged.TypeMeta = desired.TypeMeta
+	merged.Labels = make(map[string]string, len(current.Labels)+len(desired.Labels))
+	for name, value := range current.Labels {
+		merged.Labels[name] = value
+	}
+	for name, value := range desired.Labels {
+		merged.Labels[name] = value
+	}
+	merged.Spec = desired.Spec
+	return &merged
+}
+
+// getNetworkPolicyName returns the name of the NetworkPolicy of a Service or Deployment object
+func getNetworkPolicyName(target *repository.PolicyTarget) string {
+	return NetworkPolicyPrefix + strings.ToLower(target.Kind) + "-" + target.Name
+}
+
+// isControlledBy tells whether the given object is the controller owner of a NetworkPolicy
+func isControlledBy(policy *networkingv1.NetworkPolicy, target *repository.PolicyTarget) bool {
+	owner := getControllerOf(policy)
+	return owner != nil && owner.UID == target.UID
+}
+
+// getControllerOf returns the controller owner reference of a NetworkPolicy, or nil when it has none
+func getControllerOf(policy *networkingv1.NetworkPolicy) *metav1.OwnerReference {
+	for i := range policy.OwnerReferences {
+		owner := &policy.OwnerReferences[i]
+		if owner.Controller != nil && *owner.Controller {
+			return owner
+		}
+	}
+	return nil
+}
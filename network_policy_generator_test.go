@@ -0,0 +1,257 @@
+package main
+
+import (
+	"testing"
+
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestThatNetworkPolicyAllowsTheAddressesOfTheProviders(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn,offices").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32", "offices": "1.2.3.0/24", "unused": "9.9.9.9/32"}))
+
+	err := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service).Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(service.PodSelector, policy.Spec.PodSelector, "The NetworkPolicy should select the pods of the Service")
+	assert.Equal([]string{networkingv1.PolicyTypeIngress}, policy.Spec.PolicyTypes)
+	assert.Equal([]networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{
+		{IPBlock: &networkingv1.IPBlock{CIDR: "1.2.3.0/24"}},
+		{IPBlock: &networkingv1.IPBlock{CIDR: "4.4.4.4/32"}},
+	}}}, policy.Spec.Ingress)
+	assert.Equal(DMZConfigMapName, policy.Labels[ManagedByLabel])
+	assert.Equal(service.UID, getControllerOf(policy).UID, "The Service should own the NetworkPolicy, so it's garbage collected with it")
+	assert.Equal("Service", getControllerOf(policy).Kind)
+}
+
+func TestThatNetworkPolicyWithoutAddressesDeniesAllTraffic(t *testing.T) {
+	deployment := BuildPolicyTarget(repository.DeploymentKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "non-existing").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+
+	err := NewNetworkPolicyGenerator(configMapRepository, policyRepository, deployment).Sync("team/deployment:api")
+	policy, _ := policyRepository.Get("team", "dmz-deployment-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Empty(policy.Spec.Ingress, "A rule without peers would allow traffic from anywhere")
+	assert.Equal("apps/v1", getControllerOf(policy).APIVersion)
+}
+
+func TestThatNetworkPolicyIsUpdatedWhenProvidersChange(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+	generator := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service)
+	generator.Sync("team/service:api")
+
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "5.5.5.5/32"}))
+	err := generator.Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "5.5.5.5/32"}}}, policy.Spec.Ingress[0].From)
+}
+
+func TestThatChangesMadeByHandToTheNetworkPolicyAreUndone(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+	generator := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service)
+	generator.Sync("team/service:api")
+
+	port := intstr.FromInt(8080)
+	edited, _ := policyRepository.Get("team", "dmz-service-api")
+	edited.Spec.Ingress[0].Ports = []networkingv1.NetworkPolicyPort{{Port: &port}}
+	edited.Spec.Ingress[0].From = append(edited.Spec.Ingress[0].From, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{}})
+	edited.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{{}}
+	policyRepository.Save(edited)
+
+	err := generator.Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{
+		{IPBlock: &networkingv1.IPBlock{CIDR: "4.4.4.4/32"}},
+	}}}, policy.Spec.Ingress)
+	assert.Empty(policy.Spec.Egress)
+}
+
+func TestThatLabelsAndAnnotationsOfOtherToolsAreKept(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+	generator := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service)
+	generator.Sync("team/service:api")
+
+	edited, _ := policyRepository.Get("team", "dmz-service-api")
+	edited.Labels["team"] = "payments"
+	edited.Annotations = map[string]string{"example.com/audited": "true"}
+	policyRepository.Save(edited)
+
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "5.5.5.5/32"}))
+	err := generator.Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("5.5.5.5/32", policy.Spec.Ingress[0].From[0].IPBlock.CIDR)
+	assert.Equal("payments", policy.Labels["team"])
+	assert.Equal(DMZConfigMapName, policy.Labels[ManagedByLabel])
+	assert.Equal("true", policy.Annotations["example.com/audited"])
+}
+
+func TestThatNetworkPolicyCIDRsAreWrittenWithTheirNetworkAddress(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "10.0.0.1/8"}))
+
+	err := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service).Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}}, policy.Spec.Ingress[0].From, "The API server rejects CIDRs with host bits")
+}
+
+func TestThatNetworkPolicyIsDeletedWhenProvidersAnnotationIsRemoved(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	policyRepository.Save(newNetworkPolicy(service, "dmz-service-api", whitelist.NewWhitelistFromString("4.4.4.4/32")))
+	recorder := record.NewFakeRecorder(10)
+
+	generator := NewNetworkPolicyGenerator(NewConfigMapRepositoryByNamespace(), policyRepository, service)
+	generator.recorder = recorder
+	err := generator.Sync("team/service:api")
+	_, getErr := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Error(getErr, "The NetworkPolicy should be deleted")
+	assert.Equal("Normal NetworkPolicyRemoved Providers annotation was removed: deleted the 'dmz-service-api' NetworkPolicy", <-recorder.Events)
+}
+
+func TestThatNetworkPoliciesOwnedBySomeoneElseAreNeverChanged(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	existing := &networkingv1.NetworkPolicy{}
+	existing.Name = "dmz-service-api"
+	existing.Namespace = "team"
+	policyRepository.Save(existing)
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+
+	err := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service).Sync("team/service:api")
+	policy, _ := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.Empty(policy.Spec.Ingress, "The existing NetworkPolicy should not be overwritten")
+}
+
+func TestThatNoNetworkPolicyIsGeneratedForObjectsWithoutPodSelector(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "external").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	service.PodSelector = metav1.LabelSelector{}
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+
+	err := NewNetworkPolicyGenerator(configMapRepository, policyRepository, service).Sync("team/service:external")
+	_, getErr := policyRepository.Get("team", "dmz-service-external")
+
+	assert := assert.New(t)
+	assert.NoError(err, "Retrying won't make the Service select any pods")
+	assert.Error(getErr, "An empty pod selector would restrict every pod of the namespace")
+}
+
+func TestThatNetworkPolicyFollowsTheConfigMapDeletionPolicy(t *testing.T) {
+	service := BuildPolicyTarget(repository.ServiceKind, "team", "api").WithAnnotation(NetworkPolicyProvidersAnnotation, "vpn").Build()
+	policyRepository := repository.NewFakeNetworkPolicyRepository()
+	policyRepository.Save(newNetworkPolicy(service, "dmz-service-api", whitelist.NewWhitelistFromString("4.4.4.4/32")))
+	generator := NewNetworkPolicyGenerator(NewConfigMapRepositoryByNamespace(), policyRepository, service)
+
+	err := generator.Sync("team/service:api")
+	_, keptErr := policyRepository.Get("team", "dmz-service-api")
+
+	generator.whitelister.configMapDeletionPolicy = ConfigMapDeletionPolicyRemove
+	generator.Sync("team/service:api")
+	_, removedErr := policyRepository.Get("team", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.NoError(keptErr, "The last known NetworkPolicy should be kept by default")
+	assert.Error(removedErr, "The NetworkPolicy should be deleted with the remove policy")
+}
+
+func TestThatDeletedObjectsAreLeftToTheGarbageCollector(t *testing.T) {
+	err := NewNetworkPolicyGenerator(NewConfigMapRepositoryByNamespace(), repository.NewFakeNetworkPolicyRepository()).Sync("team/deployment:deleted")
+
+	assert.NoError(t, err)
+}
+
+// NewNetworkPolicyGenerator returns a generator reading providers from the 'dmz' namespace, for the given Service and Deployment objects
+func NewNetworkPolicyGenerator(configMapRepository repository.ConfigMapRepository, policyRepository repository.NetworkPolicyRepository, targets ...*repository.PolicyTarget) *NetworkPolicyGenerator {
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), configMapRepository)
+	whitelister.configNamespace = "dmz"
+	return &NetworkPolicyGenerator{
+		whitelister:      whitelister,
+		targetRepository: repository.NewFakePolicyTargetRepository(targets...),
+		policyRepository: policyRepository,
+	}
+}
+
+func BuildPolicyTarget(kind string, namespace string, name string) *PolicyTargetBuilder {
+	return &PolicyTargetBuilder{
+		kind:        kind,
+		namespace:   namespace,
+		name:        name,
+		annotations: make(map[string]string),
+	}
+}
+
+type PolicyTargetBuilder struct {
+	kind        string
+	namespace   string
+	name        string
+	annotations map[string]string
+}
+
+func (builder *PolicyTargetBuilder) WithAnnotation(name string, value string) *PolicyTargetBuilder {
+	builder.annotations[name] = value
+	return builder
+}
+
+func (builder *PolicyTargetBuilder) Build() *repository.PolicyTarget {
+	target := &repository.PolicyTarget{
+		PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": builder.name}},
+	}
+	target.APIVersion = "v1"
+	if builder.kind == repository.DeploymentKind {
+		target.APIVersion = "apps/v1"
+	}
+	target.Kind = builder.kind
+	target.Namespace = builder.namespace
+	target.Name = builder.name
+	target.UID = types.UID(builder.namespace + "-" + builder.name + "-uid")
+	target.Annotations = builder.annotations
+
+	return target
+}
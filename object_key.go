@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"k8s.io/client-go/tools/cache"
+)
+
+// objectKindSeparator separates the kind from the name of Service and Deployment objects in their queue keys.
+// Object names can't contain it, so these keys never clash with the keys of Ingress objects.
+const objectKindSeparator = ":"
+
+// objectKey returns the queue key of a Service or Deployment object, like 'namespace/service:name'
+func objectKey(kind string, namespace string, name string) string {
+	return namespace + "/" + strings.ToLower(kind) + objectKindSeparator + name
+}
+
+// isIngressKey tells whether a queue key belongs to an Ingress object, whose keys don't carry a kind
+func isIngressKey(key string) bool {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	return err != nil || !strings.Contains(name, objectKindSeparator)
+}
+
+// splitObjectKey returns the kind, namespace and name of the Service or Deployment object with the given queue key
+func splitObjectKey(key string) (string, string, string, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return "", "", "", fmt.Errorf("Error splitting meta namespace key into parts: %s", err.Error())
+	}
+	parts := strings.SplitN(name, objectKindSeparator, 2)
+	if len(parts) != 2 {
+		return "", "", "", fmt.Errorf("Key '%s' doesn't belong to a Service or Deployment object", key)
+	}
+
+	for _, kind := range []string{repository.ServiceKind, repository.DeploymentKind} {
+		if parts[0] == strings.ToLower(kind) {
+			return kind, namespace, parts[1], nil
+		}
+	}
+	return "", "", "", fmt.Errorf("Key '%s' has the unknown kind '%s'", key, parts[0])
+}
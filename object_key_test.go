@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatServiceAndDeploymentKeysCarryTheirKind(t *testing.T) {
+	key := objectKey(repository.DeploymentKind, "team", "api")
+	kind, namespace, name, err := splitObjectKey(key)
+
+	assert := assert.New(t)
+	assert.Equal("team/deployment:api", key)
+	assert.NoError(err)
+	assert.Equal([]string{repository.DeploymentKind, "team", "api"}, []string{kind, namespace, name})
+	assert.False(isIngressKey(key))
+	assert.True(isIngressKey("team/my-ingress"), "Ingress keys don't have a kind")
+
+	_, _, _, err = splitObjectKey("team/statefulset:api")
+	assert.Error(err)
+}
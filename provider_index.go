@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/fiunchinho/dmz-controller/remote"
+	"github.com/fiunchinho/dmz-controller/repository"
+)
+
+// ProviderIndex is the name of the Ingress informer index that finds Ingress objects by the providers in their annotation
+const ProviderIndex = "provider"
+
+// indexIngressByProvider returns the names of the providers used by the providers annotation of an Ingress object,
+// including the excluded ones. Providers referenced by composite providers are not included, since they depend on the ConfigMaps.
+func indexIngressByProvider(obj interface{}) ([]string, error) {
+	ingress, ok := obj.(*repository.IngressObject)
+	if !ok {
+		return []string{}, nil
+	}
+	return getAnnotatedProviders(ingress.Annotations[DMZProvidersAnnotation]), nil
+}
+
+// getAnnotatedProviders returns the names of the providers used by a providers annotation, including the excluded ones
+func getAnnotatedProviders(annotation string) []string {
+	names := []string{}
+	for _, value := range strings.Split(annotation, ",") {
+		provider := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), ExcludedProviderPrefix))
+		if provider != "" {
+			names = append(names, provider)
+		}
+	}
+	return names
+}
+
+// usesAnyProvider tells whether a providers annotation uses any of the given providers, including as excluded providers
+func usesAnyProvider(annotation string, providers []string) bool {
+	for _, used := range getAnnotatedProviders(annotation) {
+		for _, provider := range providers {
+			if used == provider {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getRemovedProviders returns the sorted names of the providers that were removed between two versions of the providers
+func getRemovedProviders(old map[string]string, cur map[string]string) []string {
+	removed := []string{}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// getChangedProviders returns the sorted names of the providers that were added, removed or changed between two versions of the providers
+func getChangedProviders(old map[string]string, cur map[string]string) []string {
+	changed := []string{}
+	for name, value := range old {
+		if curValue, ok := cur[name]; !ok || curValue != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range cur {
+		if _, ok := old[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// getDependentProviders returns the sorted names of the given providers, plus the composite providers that reference any of them,
+// directly or through other composite providers. Every given definition is taken into account, so a provider defined in several
+// places counts as referencing another one when any of its definitions does.
+func getDependentProviders(changed []string, definitions ...map[string]string) []string {
+	dependent := make(map[string]bool)
+	for _, name := range changed {
+		dependent[name] = true
+	}
+
+	// Keep adding the providers referencing the dependent ones, until no new provider is found
+	for found := true; found; {
+		found = false
+		for _, providers := range definitions {
+			for name, value := range providers {
+				if !dependent[name] && referencesAny(value, dependent) {
+					dependent[name] = true
+					found = true
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(dependent))
+	for name := range dependent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// referencesAny tells whether the addresses of a provider reference any of the given providers
+func referencesAny(addresses string, providers map[string]bool) bool {
+	if remote.IsSource(addresses) {
+		return false
+	}
+	for _, value := range strings.Split(addresses, ",") {
+		entry := strings.TrimSpace(value)
+		if strings.HasPrefix(entry, ProviderReferencePrefix) && providers[strings.TrimSpace(strings.TrimPrefix(entry, ProviderReferencePrefix))] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatIngressesAreIndexedByTheirProviders(t *testing.T) {
+	ingress := BuildIngressObject().Named("namespace/my-ingress").WithAnnotation(DMZProvidersAnnotation, " vpn, !offices,,all").Build()
+
+	names, err := indexIngressByProvider(ingress)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"vpn", "offices", "all"}, names, "Excluded providers should be indexed too")
+}
+
+func TestThatIngressesWithoutProvidersAreNotIndexed(t *testing.T) {
+	ingress := BuildIngressObject().Named("namespace/my-ingress").WithAnnotation(IngressWhitelistAnnotation, "1.2.3.4/32").Build()
+
+	names, err := indexIngressByProvider(ingress)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Empty(names)
+}
+
+func TestThatAddedRemovedAndChangedProvidersAreFound(t *testing.T) {
+	old := map[string]string{
+		"vpn":     "4.4.4.4/32",
+		"offices": "1.2.3.4/32",
+		"removed": "5.5.5.5/32",
+	}
+	cur := map[string]string{
+		"vpn":     "4.4.4.4/32",
+		"offices": "1.2.3.4/32,1.2.3.5/32",
+		"added":   "6.6.6.6/32",
+	}
+
+	assert.Equal(t, []string{"added", "offices", "removed"}, getChangedProviders(old, cur))
+}
+
+func TestThatRemovedProvidersAreDetected(t *testing.T) {
+	old := map[string]string{
+		"vpn":     "4.4.4.4/32",
+		"offices": "1.2.3.4/32",
+		"removed": "5.5.5.5/32",
+	}
+	cur := map[string]string{
+		"vpn":     "4.4.4.4/32",
+		"offices": "1.2.3.4/32,1.2.3.5/32",
+		"added":   "6.6.6.6/32",
+	}
+
+	assert.Equal(t, []string{"removed"}, getRemovedProviders(old, cur))
+	assert.Equal(t, []string{"offices", "vpn"}, getRemovedProviders(cur, map[string]string{"added": "6.6.6.6/32"}), "Deleting the ConfigMap removes every provider")
+}
+
+func TestThatAllProvidersChangeWhenTheConfigMapIsCreated(t *testing.T) {
+	cur := map[string]string{
+		"vpn":     "4.4.4.4/32",
+		"offices": "1.2.3.4/32",
+	}
+
+	assert.Equal(t, []string{"offices", "vpn"}, getChangedProviders(map[string]string{}, cur))
+}
+
+func TestThatCompositeProvidersReferencingChangedProvidersAreDependent(t *testing.T) {
+	central := map[string]string{
+		"vpn":       "4.4.4.4/32",
+		"offices":   "1.2.3.4/32",
+		"corporate": "@vpn,-4.4.4.5/32",
+		"everyone":  "@corporate,@offices",
+		"unrelated": "@offices",
+		"remote":    `{"url": "https://example.com/ips.json", "path": "$.ips[*]"}`,
+	}
+	namespaced := map[string]string{
+		"team": "8.8.8.8/32, @ vpn",
+	}
+
+	assert.Equal(t, []string{"corporate", "everyone", "team", "vpn"}, getDependentProviders([]string{"vpn"}, central, namespaced))
+}
+
+func TestThatProvidersReferencingEachOtherDoNotLoopForever(t *testing.T) {
+	providers := map[string]string{
+		"vpn":     "@offices",
+		"offices": "@vpn",
+	}
+
+	assert.Equal(t, []string{"offices", "vpn"}, getDependentProviders([]string{"vpn"}, providers))
+}
+
+func TestThatAnnotationsUsingAnyOfTheProvidersAreFound(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(usesAnyProvider("vpn, !offices", []string{"offices", "all"}), "Excluded providers are used too")
+	assert.False(usesAnyProvider("vpn", []string{"offices", "all"}))
+	assert.False(usesAnyProvider("", []string{"offices"}))
+}
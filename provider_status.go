@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// SyncProviderStatus updates the status of every WhitelistProvider object with the Ingress objects using it.
+// Ingress objects whose providers can't be read, like when the ConfigMap of their namespace doesn't exist, don't use any
+// provider, and the errors are returned together once every other status has been updated.
+func (whitelister *IngressWhitelister) SyncProviderStatus(ingresses []*repository.IngressObject) error {
+	if whitelister.providerRepository == nil {
+		return nil
+	}
+
+	errs := []error{}
+	usage := make(map[string][]string)
+	for _, ingress := range ingresses {
+		annotation, ok := ingress.Annotations[DMZProvidersAnnotation]
+		if !ok {
+			continue
+		}
+
+		providers, err := whitelister.getProviders(ingress.Namespace)
+		if err != nil {
+			glog.Warningf("Error reading the providers of Ingress '%s/%s' to update the WhitelistProvider status: %s", ingress.Namespace, ingress.Name, err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range getReferencedProviders(annotation, providers) {
+			if key, ok := whitelister.findWhitelistProvider(ingress.Namespace, name); ok {
+				usage[key] = append(usage[key], ingress.Namespace+"/"+ingress.Name)
+			}
+		}
+	}
+
+	whitelistProviders, err := whitelister.providerRepository.List(metav1.NamespaceAll)
+	if err != nil {
+		return err
+	}
+	for _, provider := range whitelistProviders {
+		ingressKeys := usage[provider.Namespace+"/"+provider.Name]
+		sort.Strings(ingressKeys)
+		if equalStrings(provider.Status.Ingresses, ingressKeys) {
+			continue
+		}
+
+		// Objects coming from the cache must not be modified, so we update a copy
+		updatedProvider := *provider
+		updatedProvider.Status = dmzv1.WhitelistProviderStatus{Ingresses: ingressKeys}
+		if _, err := whitelister.providerRepository.SaveStatus(&updatedProvider); err != nil {
+			glog.Warningf("Error updating the status of WhitelistProvider '%s/%s': %s", provider.Namespace, provider.Name, err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		glog.V(0).Infof("Updated status of WhitelistProvider '%s/%s', used by %d Ingress objects", provider.Namespace, provider.Name, len(ingressKeys))
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// findWhitelistProvider returns the key of the WhitelistProvider object with the given name used by Ingress objects in a namespace.
+// Providers are looked up with the same precedence as getProviders: the Ingress namespace overrides the central namespace,
+// and in each namespace WhitelistProvider objects override the ConfigMap. It's not found when a ConfigMap provider wins.
+func (whitelister *IngressWhitelister) findWhitelistProvider(namespace string, name string) (string, bool) {
+	namespaces := []string{namespace}
+	if configNamespace := whitelister.getConfigNamespace(namespace); configNamespace != namespace {
+		namespaces = append(namespaces, configNamespace)
+	}
+
+	for _, providerNamespace := range namespaces {
+		if _, err := whitelister.providerRepository.Get(providerNamespace, name); err == nil {
+			return providerNamespace + "/" + name, true
+		}
+		if whitelister.isConfigMapProvider(providerNamespace, name) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isConfigMapProvider tells whether the ConfigMap of a namespace defines a provider with the given name
+func (whitelister *IngressWhitelister) isConfigMapProvider(namespace string, name string) bool {
+	if whitelister.configMapRepository == nil {
+		return false
+	}
+	configMap, err := whitelister.configMapRepository.Get(namespace, DMZConfigMapName)
+	if err != nil {
+		return false
+	}
+	_, ok := configMap.Data[name]
+	return ok
+}
+
+// equalStrings tells whether two lists contain the same strings in the same order, considering nil and empty lists equal
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
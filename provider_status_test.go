@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestThatProviderStatusListsTheIngressesUsingIt(t *testing.T) {
+	providerRepository := repository.NewFakeWhitelistProviderRepository()
+	providerRepository.Save(BuildWhitelistProvider("dmz", "office", dmzv1.CIDREntry{CIDR: "1.2.3.4"}))
+	providerRepository.Save(BuildWhitelistProvider("dmz", "vpn", dmzv1.CIDREntry{CIDR: "4.4.4.4"}))
+	providerRepository.Save(BuildWhitelistProvider("team", "vpn", dmzv1.CIDREntry{CIDR: "8.8.8.8"}))
+	unused := BuildWhitelistProvider("dmz", "unused", dmzv1.CIDREntry{CIDR: "5.5.5.5"})
+	unused.Status.Ingresses = []string{"team/deleted"}
+	providerRepository.Save(unused)
+
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), NewConfigMapRepositoryByNamespace())
+	whitelister.providerRepository = providerRepository
+	whitelister.configNamespace = "dmz"
+
+	ingresses := []*repository.IngressObject{
+		BuildNamespacedIngress("dmz", "web", "office,vpn"),
+		BuildNamespacedIngress("team", "api", "vpn"),
+		BuildNamespacedIngress("team", "admin", "!office"),
+	}
+	err := whitelister.SyncProviderStatus(ingresses)
+
+	office, _ := providerRepository.Get("dmz", "office")
+	centralVpn, _ := providerRepository.Get("dmz", "vpn")
+	teamVpn, _ := providerRepository.Get("team", "vpn")
+	unused, _ = providerRepository.Get("dmz", "unused")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"dmz/web", "team/admin"}, office.Status.Ingresses, "Ingress objects using the provider are missing")
+	assert.Equal([]string{"dmz/web"}, centralVpn.Status.Ingresses, "Overridden provider should only list Ingress objects in its namespace")
+	assert.Equal([]string{"team/api"}, teamVpn.Status.Ingresses, "Ingress objects using the provider are missing")
+	assert.Empty(unused.Status.Ingresses, "Ingress objects not using the provider anymore should be removed")
+}
+
+func TestThatProvidersOverriddenByANamespaceConfigMapAreNotUsed(t *testing.T) {
+	providerRepository := repository.NewFakeWhitelistProviderRepository()
+	central := BuildWhitelistProvider("dmz", "vpn", dmzv1.CIDREntry{CIDR: "4.4.4.4"})
+	central.Status.Ingresses = []string{"team/api"}
+	providerRepository.Save(central)
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("team", map[string]string{"vpn": "8.8.8.8/32"}))
+
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), configMapRepository)
+	whitelister.providerRepository = providerRepository
+	whitelister.configNamespace = "dmz"
+
+	err := whitelister.SyncProviderStatus([]*repository.IngressObject{BuildNamespacedIngress("team", "api", "vpn")})
+	central, _ = providerRepository.Get("dmz", "vpn")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Empty(central.Status.Ingresses, "The namespace ConfigMap overrides the central WhitelistProvider")
+}
+
+func TestThatErrorsReadingTheProvidersOfANamespaceDoNotStopTheOtherStatuses(t *testing.T) {
+	providerRepository := repository.NewFakeWhitelistProviderRepository()
+	providerRepository.Save(BuildWhitelistProvider("team", "vpn", dmzv1.CIDREntry{CIDR: "4.4.4.4"}))
+	configMapRepository := &ConfigMapRepositoryFailingInNamespace{ConfigMapRepositoryByNamespace: NewConfigMapRepositoryByNamespace(), namespace: "broken"}
+
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), configMapRepository)
+	whitelister.providerRepository = providerRepository
+
+	err := whitelister.SyncProviderStatus([]*repository.IngressObject{
+		BuildNamespacedIngress("broken", "web", "vpn"),
+		BuildNamespacedIngress("team", "api", "vpn"),
+	})
+	vpn, _ := providerRepository.Get("team", "vpn")
+
+	assert := assert.New(t)
+	assert.Error(err, "The error reading the providers should be reported")
+	assert.Equal([]string{"team/api"}, vpn.Status.Ingresses, "The status should be updated for the Ingress objects whose providers were read")
+}
+
+func BuildNamespacedIngress(namespace string, name string, providers string) *repository.IngressObject {
+	ingress := BuildIngressObject().Named(name).WithAnnotation(DMZProvidersAnnotation, providers).Build()
+	ingress.Namespace = namespace
+
+	return ingress
+}
+
+type ConfigMapRepositoryFailingInNamespace struct {
+	*ConfigMapRepositoryByNamespace
+	namespace string
+}
+
+func (m *ConfigMapRepositoryFailingInNamespace) Get(namespace string, key string) (*v1.ConfigMap, error) {
+	if namespace == m.namespace {
+		return nil, errors.New("failed")
+	}
+	return m.ConfigMapRepositoryByNamespace.Get(namespace, key)
+}
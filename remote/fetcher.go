@@ -0,0 +1,162 @@
+package remote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Fetcher downloads the addresses of remote Sources, keeping the last good result of each of them
+type Fetcher struct {
+	client *http.Client
+	// referenced returns the Sources some provider definition still uses. The rest stop being refreshed.
+	referenced func() []*Source
+	onChange   func()
+
+	mutex     sync.Mutex
+	sources   map[string]*Source
+	addresses map[string][]string
+}
+
+// NewFetcher returns a Fetcher using the given HTTP client.
+// The referenced function returns the Sources still used by the providers, and when nil, every known Source keeps being refreshed.
+// The onChange function is called whenever a refresh changes the addresses of any Source.
+func NewFetcher(client *http.Client, referenced func() []*Source, onChange func()) *Fetcher {
+	return &Fetcher{
+		client:     client,
+		referenced: referenced,
+		onChange:   onChange,
+		sources:    make(map[string]*Source),
+		addresses:  make(map[string][]string),
+	}
+}
+
+// Addresses returns the last good addresses of the given Source.
+// The first time a Source is requested, it's fetched right away and kept to be refreshed periodically, for as long as it's referenced.
+func (fetcher *Fetcher) Addresses(source *Source) ([]string, error) {
+	key := source.key()
+
+	fetcher.mutex.Lock()
+	addresses, ok := fetcher.addresses[key]
+	fetcher.mutex.Unlock()
+	if ok {
+		return addresses, nil
+	}
+
+	addresses, err := fetcher.fetch(source)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher.mutex.Lock()
+	fetcher.sources[key] = source
+	fetcher.addresses[key] = addresses
+	fetcher.mutex.Unlock()
+
+	return addresses, nil
+}
+
+// Refresh fetches again every known Source that is still referenced. When fetching fails, the last good addresses are kept.
+// Sources that are not referenced anymore are forgotten, so they are fetched right away if they are ever requested again.
+func (fetcher *Fetcher) Refresh() {
+	var referenced map[string]bool
+	if fetcher.referenced != nil {
+		referenced = make(map[string]bool)
+		for _, source := range fetcher.referenced() {
+			referenced[source.key()] = true
+		}
+	}
+
+	fetcher.mutex.Lock()
+	sources := make(map[string]*Source, len(fetcher.sources))
+	for key, source := range fetcher.sources {
+		if referenced != nil && !referenced[key] {
+			glog.V(0).Infof("Addresses published at '%s' are not used anymore, they won't be refreshed", source.URL)
+			delete(fetcher.sources, key)
+			delete(fetcher.addresses, key)
+			continue
+		}
+		sources[key] = source
+	}
+	fetcher.mutex.Unlock()
+
+	changed := false
+	for key, source := range sources {
+		addresses, err := fetcher.fetch(source)
+		if err != nil {
+			glog.Warningf("Keeping the last known addresses of '%s': %s", source.URL, err)
+			continue
+		}
+
+		fetcher.mutex.Lock()
+		if !reflect.DeepEqual(fetcher.addresses[key], addresses) {
+			glog.V(0).Infof("Addresses published at '%s' changed", source.URL)
+			fetcher.addresses[key] = addresses
+			changed = true
+		}
+		fetcher.mutex.Unlock()
+	}
+
+	if changed && fetcher.onChange != nil {
+		fetcher.onChange()
+	}
+}
+
+// Run refreshes every known Source periodically, until the stop channel is closed
+func (fetcher *Fetcher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(fetcher.Refresh, interval, stopCh)
+}
+
+// fetch downloads and parses the document published by the Source
+func (fetcher *Fetcher) fetch(source *Source) ([]string, error) {
+	response, err := fetcher.client.Get(source.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching '%s': %s", source.URL, response.Status)
+	}
+
+	document, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := source.Parse(document)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %s", source.URL, err)
+	}
+	addresses := validAddresses(source, parsed)
+	// An empty list is most likely a publishing error, and would remove every address of the provider
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no addresses found at '%s'", source.URL)
+	}
+	return addresses, nil
+}
+
+// validAddresses returns the published entries that are IPs or CIDRs. Anything else is skipped, so a published document
+// can't use the syntax of providers, like referencing other providers with '@' or excluding ranges with '-'.
+func validAddresses(source *Source, entries []string) []string {
+	addresses := []string{}
+	for _, entry := range entries {
+		address := strings.TrimSpace(entry)
+		if net.ParseIP(address) == nil {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				glog.Warningf("Skipping '%s' published at '%s', because it's neither an IP nor a CIDR", entry, source.URL)
+				continue
+			}
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatAddressesAreFetchedFromTheSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1.2.3.4\n5.6.7.8\n"))
+	}))
+	defer server.Close()
+
+	addresses, err := NewFetcher(http.DefaultClient, nil, nil).Addresses(&Source{URL: server.URL, Format: FormatText})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"1.2.3.4", "5.6.7.8"}, addresses, "Fetched addresses are wrong")
+}
+
+func TestThatTheLastGoodAddressesAreKeptWhenRefreshFails(t *testing.T) {
+	responses := []string{"1.2.3.4", "", "5.6.7.8"}
+	statuses := []int{http.StatusOK, http.StatusInternalServerError, http.StatusOK}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests])
+		w.Write([]byte(responses[requests]))
+		requests++
+	}))
+	defer server.Close()
+
+	changes := 0
+	fetcher := NewFetcher(http.DefaultClient, nil, func() { changes++ })
+	source := &Source{URL: server.URL, Format: FormatText}
+
+	assert := assert.New(t)
+	fetcher.Addresses(source)
+
+	fetcher.Refresh()
+	addresses, _ := fetcher.Addresses(source)
+	assert.Equal([]string{"1.2.3.4"}, addresses, "Last good addresses must be kept when the source fails")
+	assert.Equal(0, changes, "Failed refreshes are not changes")
+
+	fetcher.Refresh()
+	addresses, _ = fetcher.Addresses(source)
+	assert.Equal([]string{"5.6.7.8"}, addresses, "Addresses must be refreshed")
+	assert.Equal(1, changes, "Changes must be notified")
+}
+
+func TestThatEmptyDocumentsAreAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# nothing here\n"))
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher(http.DefaultClient, nil, nil).Addresses(&Source{URL: server.URL, Format: FormatText})
+
+	assert := assert.New(t)
+	assert.Error(err, "An empty list of addresses is not a valid result")
+}
+
+func TestThatOnlyIPsAndCIDRsAreFetched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1.2.3.4\n@other\n-10.0.0.0/8\n!vpn\n5.6.7.0/24\nnonsense\n2001:db8::/32\n"))
+	}))
+	defer server.Close()
+
+	addresses, err := NewFetcher(http.DefaultClient, nil, nil).Addresses(&Source{URL: server.URL, Format: FormatText})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"1.2.3.4", "5.6.7.0/24", "2001:db8::/32"}, addresses, "Published entries using the syntax of providers must be skipped")
+}
+
+func TestThatReferencedSourcesKeepBeingRefreshed(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1.2.3.4"))
+		requests++
+	}))
+	defer server.Close()
+
+	source := &Source{URL: server.URL, Format: FormatText}
+	referenced := []*Source{source}
+	fetcher := NewFetcher(http.DefaultClient, func() []*Source { return referenced }, nil)
+	fetcher.Addresses(source)
+
+	fetcher.Refresh()
+	fetcher.Refresh()
+	fetcher.Refresh()
+
+	assert := assert.New(t)
+	assert.Equal(4, requests, "Sources must be refreshed while they are referenced, even if nothing requests them")
+
+	referenced = []*Source{}
+	fetcher.Refresh()
+
+	assert.Equal(4, requests, "Sources not referenced anymore must not be refreshed")
+	assert.Empty(fetcher.sources, "Sources not referenced anymore must be forgotten")
+}
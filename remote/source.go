@@ -0,0 +1,175 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	// FormatText is a plain text list, with one address per line
+	FormatText = "text"
+	// FormatJSON is a JSON document, whose addresses are found with a JSONPath expression
+	FormatJSON = "json"
+	// FormatAWS is the AWS ip-ranges.json document
+	FormatAWS = "aws"
+)
+
+// Source describes a list of addresses published at an HTTP endpoint
+type Source struct {
+	// URL is the HTTP endpoint publishing the addresses
+	URL string `json:"url"`
+	// Format is the format of the published document: text, json or aws. Defaults to text.
+	Format string `json:"format,omitempty"`
+	// JSONPath is the expression that finds the addresses in a json document, like '{.probes[*].ip}'
+	JSONPath string `json:"jsonPath,omitempty"`
+	// Service filters the prefixes of an aws document by service, like 'CLOUDFRONT'
+	Service string `json:"service,omitempty"`
+	// Region filters the prefixes of an aws document by region, like 'eu-west-1'
+	Region string `json:"region,omitempty"`
+}
+
+// IsSource tells whether a provider value is the definition of a remote Source instead of a list of addresses
+func IsSource(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "{")
+}
+
+// ParseSource parses the JSON definition of a remote Source
+func ParseSource(value string) (*Source, error) {
+	source := &Source{}
+	if err := json.Unmarshal([]byte(value), source); err != nil {
+		return nil, err
+	}
+	if source.URL == "" {
+		return nil, fmt.Errorf("the url of the source is missing")
+	}
+	if source.Format == "" {
+		source.Format = FormatText
+	}
+
+	switch source.Format {
+	case FormatText, FormatAWS:
+	case FormatJSON:
+		if source.JSONPath == "" {
+			return nil, fmt.Errorf("the jsonPath expression is needed for json sources")
+		}
+		if err := jsonpath.New(source.URL).Parse(source.JSONPath); err != nil {
+			return nil, fmt.Errorf("invalid jsonPath expression '%s': %s", source.JSONPath, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", source.Format)
+	}
+
+	return source, nil
+}
+
+// key identifies the Source, so that different providers using the same Source share the fetched addresses
+func (source *Source) key() string {
+	key, _ := json.Marshal(source)
+	return string(key)
+}
+
+// Parse extracts the addresses from a document published by the Source
+func (source *Source) Parse(document []byte) ([]string, error) {
+	switch source.Format {
+	case FormatJSON:
+		return parseJSON(document, source.JSONPath)
+	case FormatAWS:
+		return parseAWS(document, source.Service, source.Region)
+	default:
+		return parseText(document)
+	}
+}
+
+// parseText extracts one address per line, skipping empty lines and comments starting with '#'
+func parseText(document []byte) ([]string, error) {
+	addresses := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(document))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index > -1 {
+			line = line[:index]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			addresses = append(addresses, line)
+		}
+	}
+	return addresses, scanner.Err()
+}
+
+// parseJSON extracts the strings found by a JSONPath expression
+func parseJSON(document []byte, expression string) ([]string, error) {
+	var data interface{}
+	if err := json.Unmarshal(document, &data); err != nil {
+		return nil, err
+	}
+
+	path := jsonpath.New("addresses")
+	if err := path.Parse(expression); err != nil {
+		return nil, err
+	}
+	results, err := path.FindResults(data)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, result := range results {
+		for _, value := range result {
+			switch address := value.Interface().(type) {
+			case string:
+				addresses = append(addresses, address)
+			case []interface{}:
+				for _, item := range address {
+					if itemAddress, ok := item.(string); ok {
+						addresses = append(addresses, itemAddress)
+					}
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// awsIPRanges is the format of the AWS ip-ranges.json document
+type awsIPRanges struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// parseAWS extracts the IPv4 and IPv6 prefixes of the AWS ip-ranges.json document, filtered by service and region when given
+func parseAWS(document []byte, service string, region string) ([]string, error) {
+	ranges := awsIPRanges{}
+	if err := json.Unmarshal(document, &ranges); err != nil {
+		return nil, err
+	}
+
+	matches := func(prefixService string, prefixRegion string) bool {
+		return (service == "" || strings.EqualFold(service, prefixService)) && (region == "" || strings.EqualFold(region, prefixRegion))
+	}
+
+	addresses := []string{}
+	for _, prefix := range ranges.Prefixes {
+		if matches(prefix.Service, prefix.Region) {
+			addresses = append(addresses, prefix.IPPrefix)
+		}
+	}
+	for _, prefix := range ranges.IPv6Prefixes {
+		if matches(prefix.Service, prefix.Region) {
+			addresses = append(addresses, prefix.IPv6Prefix)
+		}
+	}
+	return addresses, nil
+}
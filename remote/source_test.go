@@ -0,0 +1,78 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatSourcesAreParsedFromJSON(t *testing.T) {
+	source, err := ParseSource(`{"url": "https://example.com/ips.txt"}`)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(&Source{URL: "https://example.com/ips.txt", Format: FormatText}, source, "Text is the default format")
+}
+
+func TestThatInvalidSourcesAreRejected(t *testing.T) {
+	assert := assert.New(t)
+	_, err := ParseSource(`{"format": "text"}`)
+	assert.Error(err, "The url is required")
+	_, err = ParseSource(`{"url": "https://example.com", "format": "xml"}`)
+	assert.Error(err, "Unknown formats must be rejected")
+	_, err = ParseSource(`{"url": "https://example.com", "format": "json"}`)
+	assert.Error(err, "JSON sources need a jsonPath expression")
+	_, err = ParseSource(`{"url": "https://example.com", "format": "json", "jsonPath": "{.items[*"}`)
+	assert.Error(err, "Invalid jsonPath expressions must be rejected")
+}
+
+func TestThatProviderValuesCanBeRecognisedAsSources(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsSource(` {"url": "https://example.com"}`), "JSON objects are sources")
+	assert.False(IsSource("1.2.3.4/32,@office"), "Lists of addresses are not sources")
+}
+
+func TestThatTextDocumentsAreParsed(t *testing.T) {
+	source := &Source{Format: FormatText}
+	addresses, err := source.Parse([]byte("# probes\n1.2.3.4\n\n  5.6.7.0/24 # europe\n2001:db8::1\n"))
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"1.2.3.4", "5.6.7.0/24", "2001:db8::1"}, addresses, "Every line must be an address")
+}
+
+func TestThatJSONDocumentsAreParsedWithJSONPath(t *testing.T) {
+	source := &Source{Format: FormatJSON, JSONPath: "{.probes[*].ip}"}
+	addresses, err := source.Parse([]byte(`{"probes": [{"name": "a", "ip": "1.2.3.4"}, {"name": "b", "ip": "5.6.7.8"}]}`))
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"1.2.3.4", "5.6.7.8"}, addresses, "Addresses found by the expression are missing")
+}
+
+func TestThatJSONPathCanFindArraysOfAddresses(t *testing.T) {
+	source := &Source{Format: FormatJSON, JSONPath: "{.egress}"}
+	addresses, err := source.Parse([]byte(`{"egress": ["1.2.3.4", "5.6.7.8"]}`))
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"1.2.3.4", "5.6.7.8"}, addresses, "Addresses in the array are missing")
+}
+
+func TestThatAWSDocumentsAreFilteredByServiceAndRegion(t *testing.T) {
+	document := []byte(`{
+		"prefixes": [
+			{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT"},
+			{"ip_prefix": "52.94.0.0/22", "region": "us-east-1", "service": "AMAZON"},
+			{"ip_prefix": "52.46.0.0/18", "region": "eu-west-1", "service": "CLOUDFRONT"}
+		],
+		"ipv6_prefixes": [
+			{"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT"}
+		]
+	}`)
+
+	assert := assert.New(t)
+	addresses, err := (&Source{Format: FormatAWS, Service: "CLOUDFRONT", Region: "GLOBAL"}).Parse(document)
+	assert.NoError(err)
+	assert.Equal([]string{"13.32.0.0/15", "2600:9000::/28"}, addresses, "Only global CloudFront prefixes expected")
+
+	addresses, err = (&Source{Format: FormatAWS, Service: "cloudfront"}).Parse(document)
+	assert.NoError(err)
+	assert.Equal([]string{"13.32.0.0/15", "52.46.0.0/18", "2600:9000::/28"}, addresses, "CloudFront prefixes of every region expected")
+}
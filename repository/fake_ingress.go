@@ -1,23 +1,56 @@
 package repository
 
-import (
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
-)
-
 // FakeIngress is an InMemory implementation of an Ingress repository
 type FakeIngress struct {
-	objects []v1beta1.Ingress
+	objects []IngressObject
+}
+
+// Get retrieves a copy of the last saved ingress object
+func (h *FakeIngress) Get(namespace string, key string) (*IngressObject, error) {
+	if len(h.objects) == 0 {
+		return nil, newIngressNotFound(NetworkingV1, key)
+	}
+	return copyIngress(&h.objects[len(h.objects)-1]), nil
 }
 
-// Get retrieves an ingress object by its name
-func (h *FakeIngress) Get(namespace string, key string) (*v1beta1.Ingress, error) {
-	return &h.objects[0], nil
+// Reload retrieves a copy of the last saved ingress object, like Get, because there is no cache to bypass
+func (h *FakeIngress) Reload(namespace string, key string) (*IngressObject, error) {
+	return h.Get(namespace, key)
+}
+
+// Save stores a copy of the given Ingress object. When annotations are named, only those annotations of the last saved
+// Ingress object are changed, like the real repository patches them.
+func (h *FakeIngress) Save(ingress *IngressObject, annotations ...string) (*IngressObject, error) {
+	if len(annotations) == 0 || len(h.objects) == 0 {
+		h.objects = append(h.objects, *copyIngress(ingress))
+		return ingress, nil
+	}
+
+	saved := copyIngress(&h.objects[len(h.objects)-1])
+	if saved.Annotations == nil {
+		saved.Annotations = make(map[string]string)
+	}
+	for name, value := range SelectAnnotations(ingress.Annotations, annotations) {
+		if value == nil {
+			delete(saved.Annotations, name)
+		} else {
+			saved.Annotations[name] = *value
+		}
+	}
+	h.objects = append(h.objects, *saved)
+	return copyIngress(saved), nil
 }
 
-// Save saves the given Ingress object to the k8s API
-func (h *FakeIngress) Save(ingress *v1beta1.Ingress) (*v1beta1.Ingress, error) {
-	h.objects = append(h.objects, *ingress)
-	return ingress, nil
+// copyIngress returns a copy of the given Ingress object with its own annotations, like the copies handed out by the real repository
+func copyIngress(ingress *IngressObject) *IngressObject {
+	copied := *ingress
+	if ingress.Annotations != nil {
+		copied.Annotations = make(map[string]string, len(ingress.Annotations))
+		for name, value := range ingress.Annotations {
+			copied.Annotations[name] = value
+		}
+	}
+	return &copied
 }
 
 // NewFakeIngressRepository returns an instance of the repository
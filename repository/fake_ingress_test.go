@@ -4,12 +4,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 )
 
 func TestThatIngressesCanBeSavedAndRetrieved(t *testing.T) {
 	ingressRepository := NewFakeIngressRepository()
-	ingress := &v1beta1.Ingress{}
+	ingress := &IngressObject{}
 	ingress.Name = "my-ingress"
 	ingress.Namespace = "namespace"
 
@@ -20,3 +19,33 @@ func TestThatIngressesCanBeSavedAndRetrieved(t *testing.T) {
 	assert := assert.New(t)
 	assert.Equal(ingress, fetchedIngress, "The saved Ingress object was not fetched correctly")
 }
+
+func TestThatChangingARetrievedIngressDoesNotChangeTheStoredOne(t *testing.T) {
+	ingressRepository := NewFakeIngressRepository()
+	ingress := &IngressObject{}
+	ingress.Name = "my-ingress"
+	ingress.Annotations = map[string]string{"armesto.net/ingress-providers": "vpn"}
+	ingressRepository.Save(ingress)
+
+	fetchedIngress, _ := ingressRepository.Get("namespace", "my-ingress")
+	fetchedIngress.Annotations["armesto.net/ingress-providers"] = "offices"
+
+	storedIngress, _ := ingressRepository.Get("namespace", "my-ingress")
+	assert.Equal(t, "vpn", storedIngress.Annotations["armesto.net/ingress-providers"], "Only saving should change the stored Ingress object")
+}
+
+func TestThatOnlyTheNamedAnnotationsAreSaved(t *testing.T) {
+	ingressRepository := NewFakeIngressRepository()
+	ingress := &IngressObject{}
+	ingress.Name = "my-ingress"
+	ingress.Annotations = map[string]string{"armesto.net/ingress-providers": "vpn", "armesto.net/dmz-controller-managed-cidr": "4.4.4.4/32"}
+	ingressRepository.Save(ingress)
+
+	stale := &IngressObject{}
+	stale.Name = "my-ingress"
+	stale.Annotations = map[string]string{"armesto.net/ingress-providers": "offices"}
+	ingressRepository.Save(stale, "armesto.net/dmz-controller-managed-cidr")
+
+	storedIngress, _ := ingressRepository.Get("namespace", "my-ingress")
+	assert.Equal(t, map[string]string{"armesto.net/ingress-providers": "vpn"}, storedIngress.Annotations, "Only the named annotations should be saved")
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// FakeNetworkPolicy is an InMemory implementation of a NetworkPolicy repository
+type FakeNetworkPolicy struct {
+	policies map[string]networkingv1.NetworkPolicy
+}
+
+// Get retrieves a NetworkPolicy object by its name
+func (h *FakeNetworkPolicy) Get(namespace string, key string) (*networkingv1.NetworkPolicy, error) {
+	policy, ok := h.policies[namespace+"/"+key]
+	if !ok {
+		return nil, errors.NewNotFound(networkingv1.Resource(networkingv1.NetworkPolicyResource), key)
+	}
+	return &policy, nil
+}
+
+// Save stores the given NetworkPolicy to the repository, giving it a resource version like the k8s API does
+func (h *FakeNetworkPolicy) Save(policy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	saved := *policy
+	saved.ResourceVersion = "1"
+	h.policies[policy.Namespace+"/"+policy.Name] = saved
+	return &saved, nil
+}
+
+// Delete removes a NetworkPolicy object by its name
+func (h *FakeNetworkPolicy) Delete(namespace string, key string) error {
+	if _, ok := h.policies[namespace+"/"+key]; !ok {
+		return errors.NewNotFound(networkingv1.Resource(networkingv1.NetworkPolicyResource), key)
+	}
+	delete(h.policies, namespace+"/"+key)
+	return nil
+}
+
+// NewFakeNetworkPolicyRepository returns an instance of the repository
+func NewFakeNetworkPolicyRepository() NetworkPolicyRepository {
+	return &FakeNetworkPolicy{
+		policies: make(map[string]networkingv1.NetworkPolicy),
+	}
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"testing"
+
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatNetworkPoliciesCanBeSavedRetrievedAndDeleted(t *testing.T) {
+	policyRepository := NewFakeNetworkPolicyRepository()
+	policy := &networkingv1.NetworkPolicy{}
+	policy.Name = "dmz-service-api"
+	policy.Namespace = "namespace"
+
+	saved, _ := policyRepository.Save(policy)
+	fetchedPolicy, _ := policyRepository.Get("namespace", "dmz-service-api")
+	_, err := policyRepository.Get("another-namespace", "dmz-service-api")
+
+	assert := assert.New(t)
+	assert.Equal(saved, fetchedPolicy, "The saved NetworkPolicy object was not fetched correctly")
+	assert.NotEmpty(fetchedPolicy.ResourceVersion, "Saved NetworkPolicy objects have a resource version")
+	assert.Error(err, "NetworkPolicy objects belong to a namespace")
+
+	assert.NoError(policyRepository.Delete("namespace", "dmz-service-api"))
+	_, err = policyRepository.Get("namespace", "dmz-service-api")
+	assert.Error(err, "The deleted NetworkPolicy object was fetched")
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FakePolicyTarget is an InMemory implementation of a PolicyTarget repository
+type FakePolicyTarget struct {
+	targets []PolicyTarget
+}
+
+// Get retrieves a copy of the given PolicyTarget object by its kind and name
+func (h *FakePolicyTarget) Get(kind string, namespace string, key string) (*PolicyTarget, error) {
+	for _, target := range h.targets {
+		if target.Kind == kind && target.Namespace == namespace && target.Name == key {
+			return &target, nil
+		}
+	}
+	return nil, newPolicyTargetNotFound(kind, key)
+}
+
+// List retrieves copies of the given PolicyTarget objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *FakePolicyTarget) List(namespace string) ([]*PolicyTarget, error) {
+	targets := []*PolicyTarget{}
+	for _, target := range h.targets {
+		if namespace == metav1.NamespaceAll || target.Namespace == namespace {
+			target := target
+			targets = append(targets, &target)
+		}
+	}
+	return targets, nil
+}
+
+// NewFakePolicyTargetRepository returns an instance of the repository holding the given objects
+func NewFakePolicyTargetRepository(targets ...*PolicyTarget) PolicyTargetRepository {
+	repository := &FakePolicyTarget{}
+	for _, target := range targets {
+		repository.targets = append(repository.targets, *target)
+	}
+	return repository
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestThatPolicyTargetsAreRetrievedByKind(t *testing.T) {
+	service := &PolicyTarget{}
+	service.Kind = ServiceKind
+	service.Name = "api"
+	service.Namespace = "namespace"
+	targetRepository := NewFakePolicyTargetRepository(service)
+
+	fetchedTarget, _ := targetRepository.Get(ServiceKind, "namespace", "api")
+	targets, _ := targetRepository.List("namespace")
+	_, err := targetRepository.Get(DeploymentKind, "namespace", "api")
+
+	assert := assert.New(t)
+	assert.Equal(service, fetchedTarget, "The PolicyTarget object was not fetched correctly")
+	assert.Equal([]*PolicyTarget{service}, targets, "The PolicyTarget object was not listed")
+	assert.True(errors.IsNotFound(err), "Services and Deployments with the same name are different objects")
+}
+
+func TestThatDeploymentsWithoutSelectorSelectNoPods(t *testing.T) {
+	deployment := &DeploymentObject{}
+	deployment.Name = "api"
+
+	target := newDeploymentTarget(deployment)
+
+	assert := assert.New(t)
+	assert.Equal("apps/v1", target.APIVersion)
+	assert.Equal(DeploymentKind, target.Kind)
+	assert.Empty(target.PodSelector.MatchLabels)
+	assert.Empty(target.PodSelector.MatchExpressions)
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// FakeService is an InMemory implementation of a Service repository
+type FakeService struct {
+	services map[string]v1.Service
+}
+
+// Get retrieves a Service object by its name
+func (h *FakeService) Get(namespace string, key string) (*v1.Service, error) {
+	service, ok := h.services[namespace+"/"+key]
+	if !ok {
+		return nil, errors.NewNotFound(v1.Resource("services"), key)
+	}
+	return &service, nil
+}
+
+// Reload retrieves a Service object by its name, like Get, because there is no cache to bypass
+func (h *FakeService) Reload(namespace string, key string) (*v1.Service, error) {
+	return h.Get(namespace, key)
+}
+
+// List retrieves all the Service objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *FakeService) List(namespace string) ([]*v1.Service, error) {
+	services := []*v1.Service{}
+	for _, service := range h.services {
+		if namespace == metav1.NamespaceAll || service.Namespace == namespace {
+			service := service
+			services = append(services, &service)
+		}
+	}
+	return services, nil
+}
+
+// Save stores the given Service to the repository. When annotations are named and the Service was already stored,
+// only its source ranges and those annotations are changed, like the real repository patches them.
+func (h *FakeService) Save(service *v1.Service, annotations ...string) (*v1.Service, error) {
+	key := service.Namespace + "/" + service.Name
+	stored, ok := h.services[key]
+	if len(annotations) == 0 || !ok {
+		h.services[key] = *service
+		return service, nil
+	}
+
+	saved := stored
+	saved.Annotations = make(map[string]string, len(stored.Annotations))
+	for name, value := range stored.Annotations {
+		saved.Annotations[name] = value
+	}
+	for name, value := range SelectAnnotations(service.Annotations, annotations) {
+		if value == nil {
+			delete(saved.Annotations, name)
+		} else {
+			saved.Annotations[name] = *value
+		}
+	}
+	saved.Spec.LoadBalancerSourceRanges = service.Spec.LoadBalancerSourceRanges
+	h.services[key] = saved
+	return &saved, nil
+}
+
+// NewFakeServiceRepository returns an instance of the repository
+func NewFakeServiceRepository() ServiceRepository {
+	return &FakeService{
+		services: make(map[string]v1.Service),
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestThatServicesCanBeSavedAndRetrieved(t *testing.T) {
+	serviceRepository := NewFakeServiceRepository()
+	service := &v1.Service{}
+	service.Name = "api"
+	service.Namespace = "namespace"
+
+	serviceRepository.Save(service)
+
+	fetchedService, _ := serviceRepository.Get("namespace", "api")
+	services, _ := serviceRepository.List("namespace")
+	_, err := serviceRepository.Get("another-namespace", "api")
+
+	assert := assert.New(t)
+	assert.Equal(service, fetchedService, "The saved Service object was not fetched correctly")
+	assert.Equal([]*v1.Service{service}, services, "The saved Service object was not listed")
+	assert.Error(err, "Service objects belong to a namespace")
+}
+
+func TestThatOnlyTheSourceRangesAndTheNamedAnnotationsOfServicesAreSaved(t *testing.T) {
+	serviceRepository := NewFakeServiceRepository()
+	service := &v1.Service{}
+	service.Name = "api"
+	service.Namespace = "namespace"
+	service.Annotations = map[string]string{"armesto.net/ingress-providers": "vpn", "armesto.net/dmz-controller-managed-cidr": "4.4.4.4/32"}
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	serviceRepository.Save(service)
+
+	stale := &v1.Service{}
+	stale.Name = "api"
+	stale.Namespace = "namespace"
+	stale.Annotations = map[string]string{"armesto.net/ingress-providers": "offices"}
+	stale.Spec.LoadBalancerSourceRanges = []string{"1.2.3.4/32"}
+	serviceRepository.Save(stale, "armesto.net/dmz-controller-managed-cidr")
+
+	storedService, _ := serviceRepository.Get("namespace", "api")
+
+	assert := assert.New(t)
+	assert.Equal(map[string]string{"armesto.net/ingress-providers": "vpn"}, storedService.Annotations, "Only the named annotations should be saved")
+	assert.Equal([]string{"1.2.3.4/32"}, storedService.Spec.LoadBalancerSourceRanges)
+	assert.Equal(v1.ServiceTypeLoadBalancer, storedService.Spec.Type, "Other fields should be kept")
+}
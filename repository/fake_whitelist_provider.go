@@ -0,0 +1,57 @@
+package repository
+
+import (
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FakeWhitelistProvider is an InMemory implementation of a WhitelistProvider repository
+type FakeWhitelistProvider struct {
+	providers map[string]dmzv1.WhitelistProvider
+}
+
+// Get retrieves a WhitelistProvider object by its name
+func (h *FakeWhitelistProvider) Get(namespace string, key string) (*dmzv1.WhitelistProvider, error) {
+	provider, ok := h.providers[namespace+"/"+key]
+	if !ok {
+		return nil, errors.NewNotFound(dmzv1.Resource(dmzv1.WhitelistProviderResource), key)
+	}
+	return &provider, nil
+}
+
+// List retrieves all the WhitelistProvider objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *FakeWhitelistProvider) List(namespace string) ([]*dmzv1.WhitelistProvider, error) {
+	providers := []*dmzv1.WhitelistProvider{}
+	for _, provider := range h.providers {
+		if namespace == metav1.NamespaceAll || provider.Namespace == namespace {
+			provider := provider
+			providers = append(providers, &provider)
+		}
+	}
+	return providers, nil
+}
+
+// Save stores the given WhitelistProvider to the repository
+func (h *FakeWhitelistProvider) Save(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error) {
+	h.providers[provider.Namespace+"/"+provider.Name] = *provider
+	return provider, nil
+}
+
+// SaveStatus stores the status of the given WhitelistProvider, keeping the rest of the stored object like the status subresource does
+func (h *FakeWhitelistProvider) SaveStatus(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error) {
+	stored, ok := h.providers[provider.Namespace+"/"+provider.Name]
+	if !ok {
+		return nil, errors.NewNotFound(dmzv1.Resource(dmzv1.WhitelistProviderResource), provider.Name)
+	}
+	stored.Status = provider.Status
+	h.providers[provider.Namespace+"/"+provider.Name] = stored
+	return &stored, nil
+}
+
+// NewFakeWhitelistProviderRepository returns an instance of the repository
+func NewFakeWhitelistProviderRepository() WhitelistProviderRepository {
+	return &FakeWhitelistProvider{
+		providers: make(map[string]dmzv1.WhitelistProvider),
+	}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"testing"
+
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatWhitelistProvidersCanBeSavedAndRetrieved(t *testing.T) {
+	providerRepository := NewFakeWhitelistProviderRepository()
+	provider := &dmzv1.WhitelistProvider{}
+	provider.Name = "office"
+	provider.Namespace = "namespace"
+
+	providerRepository.Save(provider)
+
+	fetchedProvider, _ := providerRepository.Get("namespace", "office")
+	providers, _ := providerRepository.List("namespace")
+	_, err := providerRepository.Get("another-namespace", "office")
+
+	assert := assert.New(t)
+	assert.Equal(provider, fetchedProvider, "The saved WhitelistProvider object was not fetched correctly")
+	assert.Equal([]*dmzv1.WhitelistProvider{provider}, providers, "The saved WhitelistProvider object was not listed")
+	assert.Error(err, "WhitelistProvider objects belong to a namespace")
+}
+
+func TestThatOnlyTheStatusOfWhitelistProvidersIsSavedBySaveStatus(t *testing.T) {
+	providerRepository := NewFakeWhitelistProviderRepository()
+	provider := &dmzv1.WhitelistProvider{}
+	provider.Name = "office"
+	provider.Namespace = "namespace"
+	provider.Spec.Description = "Offices"
+	providerRepository.Save(provider)
+
+	updated := *provider
+	updated.Spec.Description = "Changed"
+	updated.Status.Ingresses = []string{"namespace/web"}
+	providerRepository.SaveStatus(&updated)
+
+	fetchedProvider, _ := providerRepository.Get("namespace", "office")
+	_, err := providerRepository.SaveStatus(&dmzv1.WhitelistProvider{})
+
+	assert := assert.New(t)
+	assert.Equal("Offices", fetchedProvider.Spec.Description, "The status subresource ignores changes to the spec")
+	assert.Equal([]string{"namespace/web"}, fetchedProvider.Status.Ingresses)
+	assert.Error(err, "Only existing WhitelistProvider objects have a status")
+}
@@ -1,31 +1,112 @@
 package repository
 
 import (
-	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
-// Ingress acceses k8s API to fetch/save Ingress objects
+// Ingress acceses k8s API to fetch/save Ingress objects of a given API version
 type Ingress struct {
-	client          kubernetes.Interface
-	informerFactory informers.SharedInformerFactory
+	version schema.GroupVersion
+	client  rest.Interface
+	indexer cache.Indexer
 }
 
-// Get retrieves an ingress object by its name
-func (h *Ingress) Get(namespace string, key string) (*v1beta1.Ingress, error) {
-	return h.informerFactory.Extensions().V1beta1().Ingresses().Lister().Ingresses(namespace).Get(key)
+// Get retrieves a copy of an ingress object by its name.
+// The object in the informer cache is shared, so it must never be changed.
+func (h *Ingress) Get(namespace string, key string) (*IngressObject, error) {
+	cached, exists, err := h.indexer.GetByKey(namespace + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newIngressNotFound(h.version, key)
+	}
+
+	ingress, err := api.Scheme.DeepCopy(cached)
+	if err != nil {
+		return nil, err
+	}
+	return h.withTypeMeta(ingress.(*IngressObject)), nil
+}
+
+// Reload retrieves an Ingress object by its name from the k8s API, instead of the informer cache.
+// It's used after a conflict saving the Ingress object, when the cache may not have its latest version yet.
+func (h *Ingress) Reload(namespace string, key string) (*IngressObject, error) {
+	ingress := &IngressObject{}
+	err := h.client.Get().
+		Namespace(namespace).
+		Resource(IngressResource).
+		Name(key).
+		Do().
+		Into(ingress)
+	if err != nil {
+		return nil, err
+	}
+	return h.withTypeMeta(ingress), nil
+}
+
+// Save patches the given annotations of the Ingress object, leaving any other annotation and field untouched.
+// Annotations the Ingress object doesn't have are removed. Without names, every annotation of the Ingress object is patched.
+// The patch only applies to the resource version of the given Ingress object, the one its annotations were calculated from.
+// When the Ingress object changed since then, the conflict is returned, so they are calculated again from the latest object,
+// which Reload retrieves.
+func (h *Ingress) Save(ingress *IngressObject, annotations ...string) (*IngressObject, error) {
+	patch, err := NewAnnotationsPatch(ingress.ResourceVersion, SelectAnnotations(ingress.Annotations, annotations))
+	if err != nil {
+		return nil, err
+	}
+
+	saved := &IngressObject{}
+	err = h.client.Patch(types.MergePatchType).
+		Namespace(ingress.Namespace).
+		Resource(IngressResource).
+		Name(ingress.Name).
+		Body(patch).
+		Do().
+		Into(saved)
+	if err != nil {
+		return nil, err
+	}
+	return h.withTypeMeta(saved), nil
 }
 
-// Save saves the given Ingress object to the k8s API
-func (h *Ingress) Save(ingress *v1beta1.Ingress) (*v1beta1.Ingress, error) {
-	return h.client.ExtensionsV1beta1().Ingresses(ingress.Namespace).Update(ingress)
+// withTypeMeta sets the API version and kind of an Ingress object, which the decoder drops.
+// They are needed to record Events about the Ingress object.
+func (h *Ingress) withTypeMeta(ingress *IngressObject) *IngressObject {
+	ingress.APIVersion = h.version.String()
+	ingress.Kind = "Ingress"
+	return ingress
+}
+
+// NewIngressClient returns a REST client for the Ingress objects of the given API version, which decodes them as IngressObject
+func NewIngressClient(cfg *rest.Config, version schema.GroupVersion) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(version.WithKind("Ingress"), &IngressObject{})
+	scheme.AddKnownTypeWithName(version.WithKind("IngressList"), &IngressObjectList{})
+	metav1.AddToGroupVersion(scheme, version)
+
+	config := *cfg
+	config.GroupVersion = &version
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&config)
 }
 
-// NewIngressRepository returns a repository instance
-func NewIngressRepository(client kubernetes.Interface, informerFactory informers.SharedInformerFactory) IngressRepository {
+// NewIngressRepository returns a repository instance for the Ingress objects of the given API version.
+// The client must be created with NewIngressClient, and the informer must list IngressObject objects with it.
+func NewIngressRepository(version schema.GroupVersion, client rest.Interface, informer cache.SharedIndexInformer) IngressRepository {
 	return &Ingress{
-		client:          client,
-		informerFactory: informerFactory,
+		version: version,
+		client:  client,
+		indexer: informer.GetIndexer(),
 	}
 }
@@ -1,11 +1,10 @@
 package repository
 
-import (
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
-)
-
-// IngressRepository is an interface to fetch or store Ingress
+// IngressRepository is an interface to fetch or store Ingress objects, no matter their API version
 type IngressRepository interface {
-	Get(namespace string, key string) (*v1beta1.Ingress, error)
-	Save(ingress *v1beta1.Ingress) (*v1beta1.Ingress, error)
+	Get(namespace string, key string) (*IngressObject, error)
+	// Reload retrieves the latest version of an Ingress object, which the cache of Get may not have seen yet
+	Reload(namespace string, key string) (*IngressObject, error)
+	// Save writes the named annotations of the Ingress object, or all of them without names, as long as it didn't change since it was read
+	Save(ingress *IngressObject, annotations ...string) (*IngressObject, error)
 }
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// IngressResource is the plural name of the Ingress resource, in every API version
+	IngressResource = "ingresses"
+
+	// IngressClassAnnotation is the annotation that chose the ingress controller of an Ingress object, before the ingressClassName field
+	IngressClassAnnotation = "kubernetes.io/ingress.class"
+)
+
+var (
+	// ExtensionsV1beta1 is the original API version of Ingress objects, removed in Kubernetes 1.22
+	ExtensionsV1beta1 = schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+
+	// NetworkingV1 is the API version of Ingress objects since Kubernetes 1.19
+	NetworkingV1 = schema.GroupVersion{Group: "networking.k8s.io", Version: "v1"}
+
+	// IngressVersions are the supported API versions of Ingress objects, from the most preferred one
+	IngressVersions = []schema.GroupVersion{NetworkingV1, ExtensionsV1beta1}
+)
+
+// IngressObject is the version agnostic view the controller has of an Ingress object.
+// The controller only changes the metadata, and reads the ingress class, which are the same in every API version,
+// so Ingress objects of any supported API version are decoded into this type.
+type IngressObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              IngressObjectSpec `json:"spec,omitempty"`
+}
+
+// IngressObjectSpec contains the fields of the Ingress spec read by the controller
+type IngressObjectSpec struct {
+	// IngressClassName is the name of the IngressClass of the Ingress object
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+}
+
+// Class returns the ingress class of the Ingress object, from the ingressClassName field or the legacy annotation.
+// It's empty when the Ingress object doesn't have a class.
+func (ingress *IngressObject) Class() string {
+	if ingress.Spec.IngressClassName != nil && *ingress.Spec.IngressClassName != "" {
+		return *ingress.Spec.IngressClassName
+	}
+	return ingress.Annotations[IngressClassAnnotation]
+}
+
+// IngressObjectList is a list of IngressObject objects
+type IngressObjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []IngressObject `json:"items"`
+}
+
+// ServerResourcesGetter lists the resources served by the cluster for an API version, like the discovery client does
+type ServerResourcesGetter interface {
+	ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error)
+}
+
+// DiscoverIngressVersion returns the most preferred API version of Ingress objects served by the cluster
+func DiscoverIngressVersion(discovery ServerResourcesGetter) (schema.GroupVersion, error) {
+	for _, version := range IngressVersions {
+		resources, err := discovery.ServerResourcesForGroupVersion(version.String())
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return schema.GroupVersion{}, err
+		}
+		for _, resource := range resources.APIResources {
+			if resource.Name == IngressResource {
+				return version, nil
+			}
+		}
+	}
+	return schema.GroupVersion{}, fmt.Errorf("The cluster doesn't serve Ingress objects in any of the supported API versions: %v", IngressVersions)
+}
+
+// ParseIngressVersion returns the supported API version of Ingress objects with the given name, like 'networking.k8s.io/v1'
+func ParseIngressVersion(name string) (schema.GroupVersion, error) {
+	for _, version := range IngressVersions {
+		if version.String() == name {
+			return version, nil
+		}
+	}
+	return schema.GroupVersion{}, fmt.Errorf("Unsupported Ingress API version '%s', it must be one of %v", name, IngressVersions)
+}
+
+// newIngressNotFound returns the error of an Ingress object that doesn't exist
+func newIngressNotFound(version schema.GroupVersion, name string) error {
+	return errors.NewNotFound(version.WithResource(IngressResource).GroupResource(), name)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestThatNetworkingIngressesArePreferredWhenServed(t *testing.T) {
+	discovery := FakeDiscovery{
+		"networking.k8s.io/v1": {"ingresses", "ingressclasses", "networkpolicies"},
+		"extensions/v1beta1":   {"ingresses"},
+	}
+
+	version, err := DiscoverIngressVersion(discovery)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(NetworkingV1, version)
+}
+
+func TestThatExtensionsIngressesAreUsedByOldClusters(t *testing.T) {
+	discovery := FakeDiscovery{
+		"networking.k8s.io/v1": {"networkpolicies"},
+		"extensions/v1beta1":   {"ingresses"},
+	}
+
+	version, err := DiscoverIngressVersion(discovery)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(ExtensionsV1beta1, version)
+}
+
+func TestThatDiscoveryFailsWhenNoIngressVersionIsServed(t *testing.T) {
+	_, err := DiscoverIngressVersion(FakeDiscovery{})
+
+	assert.Error(t, err)
+}
+
+func TestThatOnlySupportedIngressVersionsCanBeChosen(t *testing.T) {
+	version, err := ParseIngressVersion("extensions/v1beta1")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(ExtensionsV1beta1, version)
+
+	_, err = ParseIngressVersion("networking.k8s.io/v1beta1")
+	assert.Error(err)
+}
+
+func TestThatTheIngressClassNameTakesPrecedenceOverTheAnnotation(t *testing.T) {
+	className := "nginx"
+	ingress := &IngressObject{}
+	ingress.Annotations = map[string]string{IngressClassAnnotation: "traefik"}
+
+	assert := assert.New(t)
+	assert.Equal("traefik", ingress.Class())
+
+	ingress.Spec.IngressClassName = &className
+	assert.Equal("nginx", ingress.Class())
+
+	assert.Equal("", (&IngressObject{}).Class(), "Ingress objects can have no class")
+}
+
+// FakeDiscovery serves the given resource names for each API version
+type FakeDiscovery map[string][]string
+
+func (d FakeDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	names, ok := d[groupVersion]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{}, groupVersion)
+	}
+
+	resources := &metav1.APIResourceList{GroupVersion: groupVersion}
+	for _, name := range names {
+		resources.APIResources = append(resources.APIResources, metav1.APIResource{Name: name})
+	}
+	return resources, nil
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NetworkPolicy acceses k8s API to fetch/save NetworkPolicy objects
+type NetworkPolicy struct {
+	client  rest.Interface
+	indexer cache.Indexer
+}
+
+// Get retrieves a NetworkPolicy object by its name
+func (h *NetworkPolicy) Get(namespace string, key string) (*networkingv1.NetworkPolicy, error) {
+	obj, exists, err := h.indexer.GetByKey(namespace + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(networkingv1.Resource(networkingv1.NetworkPolicyResource), key)
+	}
+	return obj.(*networkingv1.NetworkPolicy), nil
+}
+
+// Save stores the NetworkPolicy in the k8s API. It's created when it doesn't have a resource version yet.
+func (h *NetworkPolicy) Save(policy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	request := h.client.Put().Name(policy.Name)
+	if policy.ResourceVersion == "" {
+		request = h.client.Post()
+	}
+
+	result := &networkingv1.NetworkPolicy{}
+	err := request.
+		Namespace(policy.Namespace).
+		Resource(networkingv1.NetworkPolicyResource).
+		Body(policy).
+		Do().
+		Into(result)
+	return result, err
+}
+
+// Delete removes a NetworkPolicy object by its name
+func (h *NetworkPolicy) Delete(namespace string, key string) error {
+	return h.client.Delete().
+		Namespace(namespace).
+		Resource(networkingv1.NetworkPolicyResource).
+		Name(key).
+		Do().
+		Error()
+}
+
+// NewNetworkPolicyRepository returns a repository instance.
+// The client must be created with networkingv1.NewClient, and the informer must list NetworkPolicy objects with it.
+func NewNetworkPolicyRepository(client rest.Interface, informer cache.SharedIndexInformer) NetworkPolicyRepository {
+	return &NetworkPolicy{
+		client:  client,
+		indexer: informer.GetIndexer(),
+	}
+}
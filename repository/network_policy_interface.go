@@ -0,0 +1,12 @@
+package repository
+
+import (
+	networkingv1 "github.com/fiunchinho/dmz-controller/apis/networking/v1"
+)
+
+// NetworkPolicyRepository is an interface to fetch, store or delete NetworkPolicy objects
+type NetworkPolicyRepository interface {
+	Get(namespace string, key string) (*networkingv1.NetworkPolicy, error)
+	Save(policy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error)
+	Delete(namespace string, key string) error
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"encoding/json"
+)
+
+// annotationsPatch is a JSON merge patch that only touches some annotations of an object.
+// The resource version makes the API server reject the patch when the object changed since it was read.
+type annotationsPatch struct {
+	Metadata annotationsPatchMetadata `json:"metadata"`
+}
+
+type annotationsPatchMetadata struct {
+	ResourceVersion string             `json:"resourceVersion,omitempty"`
+	Annotations     map[string]*string `json:"annotations"`
+}
+
+// SelectAnnotations returns the values of the named annotations, to be written by a patch.
+// Named annotations missing from the given annotations have a nil value, so the patch removes them.
+// Without names, every given annotation is selected.
+func SelectAnnotations(annotations map[string]string, names []string) map[string]*string {
+	selected := make(map[string]*string)
+	if len(names) == 0 {
+		for name := range annotations {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		selected[name] = nil
+		if value, ok := annotations[name]; ok {
+			selected[name] = &value
+		}
+	}
+	return selected
+}
+
+// NewAnnotationsPatch returns a JSON merge patch applying the given annotation changes to the given resource version
+func NewAnnotationsPatch(resourceVersion string, changes map[string]*string) ([]byte, error) {
+	return json.Marshal(annotationsPatch{
+		Metadata: annotationsPatchMetadata{
+			ResourceVersion: resourceVersion,
+			Annotations:     changes,
+		},
+	})
+}
+
+// sourceRangesPatch is a JSON merge patch that only touches the load balancer source ranges and some annotations of a Service.
+// Lists are replaced as a whole by merge patches, and a null list removes the field.
+type sourceRangesPatch struct {
+	Metadata annotationsPatchMetadata `json:"metadata"`
+	Spec     sourceRangesPatchSpec    `json:"spec"`
+}
+
+type sourceRangesPatchSpec struct {
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges"`
+}
+
+// NewSourceRangesPatch returns a JSON merge patch applying the given source ranges and annotation changes to the given resource version.
+// Empty source ranges remove the field.
+func NewSourceRangesPatch(resourceVersion string, sourceRanges []string, changes map[string]*string) ([]byte, error) {
+	if len(sourceRanges) == 0 {
+		sourceRanges = nil
+	}
+	return json.Marshal(sourceRangesPatch{
+		Metadata: annotationsPatchMetadata{
+			ResourceVersion: resourceVersion,
+			Annotations:     changes,
+		},
+		Spec: sourceRangesPatchSpec{
+			LoadBalancerSourceRanges: sourceRanges,
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatOnlyTheNamedAnnotationsArePatched(t *testing.T) {
+	annotations := map[string]string{
+		"ingress.kubernetes.io/whitelist-source-range": "1.2.3.4/32,4.4.4.4/32",
+		"armesto.net/ingress-providers":                "vpn",
+		"armesto.net/dmz-controller-managed-cidr":      "4.4.4.4/32",
+		"kubernetes.io/ingress.class":                  "nginx",
+	}
+
+	patch, err := NewAnnotationsPatch("42", SelectAnnotations(annotations, []string{"ingress.kubernetes.io/whitelist-source-range", "armesto.net/dmz-controller-managed-cidr"}))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.JSONEq(`{"metadata":{"resourceVersion":"42","annotations":{
+		"ingress.kubernetes.io/whitelist-source-range":"1.2.3.4/32,4.4.4.4/32",
+		"armesto.net/dmz-controller-managed-cidr":"4.4.4.4/32"
+	}}}`, string(patch))
+}
+
+func TestThatMissingAnnotationsArePatchedAsNull(t *testing.T) {
+	annotations := map[string]string{"armesto.net/ingress-providers": "vpn"}
+
+	patch, err := NewAnnotationsPatch("42", SelectAnnotations(annotations, []string{"ingress.kubernetes.io/whitelist-source-range", "armesto.net/dmz-controller-managed-cidr"}))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.JSONEq(`{"metadata":{"resourceVersion":"42","annotations":{
+		"ingress.kubernetes.io/whitelist-source-range":null,
+		"armesto.net/dmz-controller-managed-cidr":null
+	}}}`, string(patch))
+}
+
+func TestThatEveryAnnotationIsPatchedWithoutNames(t *testing.T) {
+	annotations := map[string]string{"armesto.net/ingress-providers": "vpn"}
+
+	patch, err := NewAnnotationsPatch("", SelectAnnotations(annotations, nil))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.JSONEq(`{"metadata":{"annotations":{"armesto.net/ingress-providers":"vpn"}}}`, string(patch))
+}
+
+func TestThatOnlyTheSourceRangesAndTheNamedAnnotationsArePatched(t *testing.T) {
+	annotations := map[string]string{
+		"armesto.net/ingress-providers":           "vpn",
+		"armesto.net/dmz-controller-managed-cidr": "4.4.4.4/32",
+	}
+
+	patch, err := NewSourceRangesPatch("42", []string{"1.2.3.4/32", "4.4.4.4/32"}, SelectAnnotations(annotations, []string{"armesto.net/dmz-controller-managed-cidr"}))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.JSONEq(`{
+		"metadata":{"resourceVersion":"42","annotations":{"armesto.net/dmz-controller-managed-cidr":"4.4.4.4/32"}},
+		"spec":{"loadBalancerSourceRanges":["1.2.3.4/32","4.4.4.4/32"]}
+	}`, string(patch))
+}
+
+func TestThatEmptySourceRangesArePatchedAsNull(t *testing.T) {
+	patch, err := NewSourceRangesPatch("42", []string{}, SelectAnnotations(map[string]string{}, []string{"armesto.net/dmz-controller-managed-cidr"}))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.JSONEq(`{
+		"metadata":{"resourceVersion":"42","annotations":{"armesto.net/dmz-controller-managed-cidr":null}},
+		"spec":{"loadBalancerSourceRanges":null}
+	}`, string(patch))
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PolicyTargets acceses k8s API to fetch Service and Deployment objects as PolicyTarget objects
+type PolicyTargets struct {
+	informerFactory informers.SharedInformerFactory
+	deployments     cache.Indexer
+}
+
+// Get retrieves a Service or Deployment object by its kind and name
+func (h *PolicyTargets) Get(kind string, namespace string, key string) (*PolicyTarget, error) {
+	if kind == DeploymentKind {
+		obj, exists, err := h.deployments.GetByKey(namespace + "/" + key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, newPolicyTargetNotFound(kind, key)
+		}
+		return newDeploymentTarget(obj.(*DeploymentObject)), nil
+	}
+
+	if kind != ServiceKind {
+		return nil, newPolicyTargetNotFound(kind, key)
+	}
+	service, err := h.informerFactory.Core().V1().Services().Lister().Services(namespace).Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return newServiceTarget(service), nil
+}
+
+// List retrieves all the Service and Deployment objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *PolicyTargets) List(namespace string) ([]*PolicyTarget, error) {
+	targets := []*PolicyTarget{}
+
+	services, err := h.informerFactory.Core().V1().Services().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		if namespace == metav1.NamespaceAll || service.Namespace == namespace {
+			targets = append(targets, newServiceTarget(service))
+		}
+	}
+
+	deployments := h.deployments.List()
+	if namespace != metav1.NamespaceAll {
+		if deployments, err = h.deployments.ByIndex(cache.NamespaceIndex, namespace); err != nil {
+			return nil, err
+		}
+	}
+	for _, obj := range deployments {
+		targets = append(targets, newDeploymentTarget(obj.(*DeploymentObject)))
+	}
+
+	return targets, nil
+}
+
+// newServiceTarget returns the PolicyTarget of a Service object. A Service without selector selects no pods.
+func newServiceTarget(service *v1.Service) *PolicyTarget {
+	target := &PolicyTarget{
+		ObjectMeta:  service.ObjectMeta,
+		PodSelector: metav1.LabelSelector{MatchLabels: service.Spec.Selector},
+	}
+	target.APIVersion = "v1"
+	target.Kind = ServiceKind
+	return target
+}
+
+// NewDeploymentClient returns a REST client for apps/v1 Deployment objects, which decodes them as DeploymentObject
+func NewDeploymentClient(cfg *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(AppsV1.WithKind(DeploymentKind), &DeploymentObject{})
+	scheme.AddKnownTypeWithName(AppsV1.WithKind("DeploymentList"), &DeploymentObjectList{})
+	metav1.AddToGroupVersion(scheme, AppsV1)
+
+	config := *cfg
+	config.GroupVersion = &AppsV1
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&config)
+}
+
+// NewPolicyTargetRepository returns a repository instance.
+// The informer must list DeploymentObject objects with a client created by NewDeploymentClient, and index them with cache.NamespaceIndex.
+func NewPolicyTargetRepository(informerFactory informers.SharedInformerFactory, deploymentInformer cache.SharedIndexInformer) PolicyTargetRepository {
+	return &PolicyTargets{
+		informerFactory: informerFactory,
+		deployments:     deploymentInformer.GetIndexer(),
+	}
+}
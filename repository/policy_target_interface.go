@@ -0,0 +1,7 @@
+package repository
+
+// PolicyTargetRepository is an interface to fetch the Service and Deployment objects whose pods can be protected by a NetworkPolicy
+type PolicyTargetRepository interface {
+	Get(kind string, namespace string, key string) (*PolicyTarget, error)
+	List(namespace string) ([]*PolicyTarget, error)
+}
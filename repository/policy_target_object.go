@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ServiceKind is the kind of the Service objects whose pods can be protected by a NetworkPolicy
+	ServiceKind = "Service"
+
+	// DeploymentKind is the kind of the Deployment objects whose pods can be protected by a NetworkPolicy
+	DeploymentKind = "Deployment"
+
+	// DeploymentResource is the plural name of the Deployment resource
+	DeploymentResource = "deployments"
+)
+
+// AppsV1 is the API version of the watched Deployment objects
+var AppsV1 = schema.GroupVersion{Group: "apps", Version: "v1"}
+
+// PolicyTarget is the view the controller has of an object whose pods are protected by a generated NetworkPolicy, no matter its kind.
+// Its API version and kind are always set, since they are needed to make the object the owner of its NetworkPolicy.
+type PolicyTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	// PodSelector selects the pods of the object, in its namespace
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+}
+
+// DeploymentObject is the view the controller has of a Deployment object. Only the pod selector is read.
+type DeploymentObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              DeploymentObjectSpec `json:"spec,omitempty"`
+}
+
+// DeploymentObjectSpec contains the fields of the Deployment spec read by the controller
+type DeploymentObjectSpec struct {
+	// Selector selects the pods of the Deployment object
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// DeploymentObjectList is a list of DeploymentObject objects
+type DeploymentObjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []DeploymentObject `json:"items"`
+}
+
+// newDeploymentTarget returns the PolicyTarget of a Deployment object. A Deployment without selector selects no pods.
+func newDeploymentTarget(deployment *DeploymentObject) *PolicyTarget {
+	target := &PolicyTarget{ObjectMeta: deployment.ObjectMeta}
+	target.APIVersion = AppsV1.String()
+	target.Kind = DeploymentKind
+	if deployment.Spec.Selector != nil {
+		target.PodSelector = *deployment.Spec.Selector
+	}
+	return target
+}
+
+// newPolicyTargetNotFound returns the error of a Service or Deployment object that doesn't exist
+func newPolicyTargetNotFound(kind string, name string) error {
+	if kind == DeploymentKind {
+		return errors.NewNotFound(AppsV1.WithResource(DeploymentResource).GroupResource(), name)
+	}
+	return errors.NewNotFound(schema.GroupResource{Resource: "services"}, name)
+}
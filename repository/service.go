@@ -0,0 +1,56 @@
+package repository
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Service acceses k8s API to fetch/save Service objects
+type Service struct {
+	client          kubernetes.Interface
+	informerFactory informers.SharedInformerFactory
+}
+
+// Get retrieves a Service object by its name. The object is shared with the informer cache, so it must never be changed.
+func (h *Service) Get(namespace string, key string) (*v1.Service, error) {
+	return h.informerFactory.Core().V1().Services().Lister().Services(namespace).Get(key)
+}
+
+// List retrieves all the Service objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *Service) List(namespace string) ([]*v1.Service, error) {
+	if namespace == metav1.NamespaceAll {
+		return h.informerFactory.Core().V1().Services().Lister().List(labels.Everything())
+	}
+	return h.informerFactory.Core().V1().Services().Lister().Services(namespace).List(labels.Everything())
+}
+
+// Reload retrieves a Service object by its name from the k8s API, instead of the informer cache.
+// It's used after a conflict saving the Service, when the cache may not have its latest version yet.
+func (h *Service) Reload(namespace string, key string) (*v1.Service, error) {
+	return h.client.CoreV1().Services(namespace).Get(key, metav1.GetOptions{})
+}
+
+// Save patches the load balancer source ranges and the named annotations of the Service in the k8s API.
+// Annotations the Service doesn't have are removed. Any other field is left as it is in the API, including the ones this client doesn't know.
+// The patch only applies to the resource version of the given Service, the one its source ranges were calculated from.
+// When the Service changed since then, the conflict is returned, so they are calculated again from the latest object,
+// which Reload retrieves.
+func (h *Service) Save(service *v1.Service, annotations ...string) (*v1.Service, error) {
+	patch, err := NewSourceRangesPatch(service.ResourceVersion, service.Spec.LoadBalancerSourceRanges, SelectAnnotations(service.Annotations, annotations))
+	if err != nil {
+		return nil, err
+	}
+	return h.client.CoreV1().Services(service.Namespace).Patch(service.Name, types.MergePatchType, patch)
+}
+
+// NewServiceRepository returns a repository instance
+func NewServiceRepository(client kubernetes.Interface, informerFactory informers.SharedInformerFactory) ServiceRepository {
+	return &Service{
+		client:          client,
+		informerFactory: informerFactory,
+	}
+}
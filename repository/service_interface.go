@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// ServiceRepository is an interface to fetch or store Service objects
+type ServiceRepository interface {
+	Get(namespace string, key string) (*v1.Service, error)
+	List(namespace string) ([]*v1.Service, error)
+	// Reload retrieves the latest version of a Service object, which the cache of Get may not have seen yet
+	Reload(namespace string, key string) (*v1.Service, error)
+	// Save writes the load balancer source ranges and the named annotations of the Service, as long as it didn't change since it was read
+	Save(service *v1.Service, annotations ...string) (*v1.Service, error)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WhitelistProvider acceses k8s API to fetch/save WhitelistProvider objects
+type WhitelistProvider struct {
+	client  rest.Interface
+	indexer cache.Indexer
+}
+
+// Get retrieves a WhitelistProvider object by its name
+func (h *WhitelistProvider) Get(namespace string, key string) (*dmzv1.WhitelistProvider, error) {
+	obj, exists, err := h.indexer.GetByKey(namespace + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(dmzv1.Resource(dmzv1.WhitelistProviderResource), key)
+	}
+	return obj.(*dmzv1.WhitelistProvider), nil
+}
+
+// List retrieves all the WhitelistProvider objects of a namespace, or of every namespace when passing metav1.NamespaceAll
+func (h *WhitelistProvider) List(namespace string) ([]*dmzv1.WhitelistProvider, error) {
+	var objs []interface{}
+	if namespace == metav1.NamespaceAll {
+		objs = h.indexer.List()
+	} else {
+		var err error
+		if objs, err = h.indexer.ByIndex(cache.NamespaceIndex, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	providers := make([]*dmzv1.WhitelistProvider, 0, len(objs))
+	for _, obj := range objs {
+		providers = append(providers, obj.(*dmzv1.WhitelistProvider))
+	}
+	return providers, nil
+}
+
+// Save stores the WhitelistProvider in the k8s API
+func (h *WhitelistProvider) Save(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error) {
+	result := &dmzv1.WhitelistProvider{}
+	err := h.client.Put().
+		Namespace(provider.Namespace).
+		Resource(dmzv1.WhitelistProviderResource).
+		Name(provider.Name).
+		Body(provider).
+		Do().
+		Into(result)
+	return result, err
+}
+
+// SaveStatus stores the status of the WhitelistProvider in the k8s API, through its status subresource.
+// The API server ignores any change to the rest of the object.
+func (h *WhitelistProvider) SaveStatus(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error) {
+	result := &dmzv1.WhitelistProvider{}
+	err := h.client.Put().
+		Namespace(provider.Namespace).
+		Resource(dmzv1.WhitelistProviderResource).
+		Name(provider.Name).
+		SubResource("status").
+		Body(provider).
+		Do().
+		Into(result)
+	return result, err
+}
+
+// NewWhitelistProviderRepository returns a repository instance.
+// The informer must index objects with cache.NamespaceIndex.
+func NewWhitelistProviderRepository(client rest.Interface, informer cache.SharedIndexInformer) WhitelistProviderRepository {
+	return &WhitelistProvider{
+		client:  client,
+		indexer: informer.GetIndexer(),
+	}
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	dmzv1 "github.com/fiunchinho/dmz-controller/apis/dmz/v1"
+)
+
+// WhitelistProviderRepository is an interface to fetch or store WhitelistProviders
+type WhitelistProviderRepository interface {
+	Get(namespace string, key string) (*dmzv1.WhitelistProvider, error)
+	List(namespace string) ([]*dmzv1.WhitelistProvider, error)
+	Save(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error)
+	// SaveStatus writes only the status of the WhitelistProvider, through its status subresource
+	SaveStatus(provider *dmzv1.WhitelistProvider) (*dmzv1.WhitelistProvider, error)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// ServiceWhitelister keeps the loadBalancerSourceRanges of LoadBalancer Service objects with providers up to date.
+// Like for Ingress objects, the CIDRs coming from providers are tracked in the ManagedWhitelistAnnotation, and any other range is kept.
+type ServiceWhitelister struct {
+	// whitelister resolves the providers and records Events, the same way it does for Ingress objects
+	whitelister       *IngressWhitelister
+	serviceRepository repository.ServiceRepository
+}
+
+// Whitelist adds the desired addresses to the loadBalancerSourceRanges of the Service object with the given queue key
+func (serviceWhitelister *ServiceWhitelister) Whitelist(key string) error {
+	_, namespace, name, err := splitObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	service, err := serviceWhitelister.serviceRepository.Get(namespace, name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	glog.V(0).Infof("Got '%s/%s' Service object from cache.", namespace, name)
+
+	// Like for Ingress objects, the source ranges are calculated again from the latest Service a few times before requeuing it
+	for attempt := 1; ; attempt++ {
+		err = serviceWhitelister.reconcile(namespace, name, service)
+		if !errors.IsConflict(err) || attempt == maxSaveAttempts {
+			break
+		}
+		glog.V(0).Infof("Service '%s/%s' changed while saving its source ranges, calculating them again", namespace, name)
+		if service, err = serviceWhitelister.serviceRepository.Reload(namespace, name); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	if errors.IsConflict(err) {
+		serviceWhitelister.whitelister.event(service, v1.EventTypeWarning, EventReasonSaveConflict, "The Service changed while saving its source ranges, it will be retried: %s", err.Error())
+	}
+	return err
+}
+
+// reconcile adds the desired addresses to the loadBalancerSourceRanges of the given Service object
+func (serviceWhitelister *ServiceWhitelister) reconcile(namespace string, name string, service *v1.Service) error {
+	whitelister := serviceWhitelister.whitelister
+	provider, ok := service.Annotations[DMZProvidersAnnotation]
+	if !ok {
+		if _, ok := service.Annotations[ManagedWhitelistAnnotation]; ok {
+			return serviceWhitelister.unmanage(service, "Providers annotation was removed")
+		}
+		return nil
+	}
+	// The API server rejects source ranges on Service objects of any other type
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		if _, ok := service.Annotations[ManagedWhitelistAnnotation]; ok {
+			return serviceWhitelister.unmanage(service, fmt.Sprintf("The Service type is %s", service.Spec.Type))
+		}
+		// Nothing changes until the type does, so an Event on every resync would only be noise
+		glog.Warningf("Only LoadBalancer Services have source ranges, but the type of Service '%s/%s' is %s", namespace, name, service.Spec.Type)
+		return nil
+	}
+
+	providers, err := whitelister.getProviders(namespace)
+	if errors.IsNotFound(err) {
+		return whitelister.applyConfigMapDeletionPolicy(service, "Service", "whitelist", func(reason string) error {
+			if _, ok := service.Annotations[ManagedWhitelistAnnotation]; !ok {
+				return nil
+			}
+			return serviceWhitelister.unmanage(service, reason)
+		})
+	}
+	if err != nil {
+		whitelister.recordFailure(service, err)
+		return err
+	}
+
+	previouslyManagedIps := whitelist.NewWhitelistFromString(service.Annotations[ManagedWhitelistAnnotation])
+	currentWhitelistedIps := whitelist.NewWhitelistFromArray(service.Spec.LoadBalancerSourceRanges)
+	currentWhitelistedIps.RemoveCovered(previouslyManagedIps)
+
+	if err := whitelister.resolveRemoteProviders(provider, providers); err != nil {
+		whitelister.recordFailure(service, err)
+		return err
+	}
+	if unknown := getUnknownProviders(provider, providers); len(unknown) > 0 {
+		whitelister.event(service, v1.EventTypeWarning, EventReasonUnknownProvider, "Unknown providers were skipped: %s", strings.Join(unknown, ", "))
+	}
+
+	whitelistToApply, err := getWhitelistFromProvider(provider, providers)
+	if err != nil {
+		whitelister.recordFailure(service, err)
+		return err
+	}
+	if whitelister.aggregate {
+		whitelistToApply.Aggregate()
+	}
+	glog.V(0).Infof("Whitelisting the Service object with %s IPs: %s", provider, whitelistToApply.ToString())
+	desired := newDesiredService(service)
+	desired.Annotations[ManagedWhitelistAnnotation] = whitelistToApply.ToString()
+	managedIps := whitelist.NewWhitelistFromArray(whitelistToApply.Ips)
+	whitelistToApply.Merge(currentWhitelistedIps)
+	desired.Spec.LoadBalancerSourceRanges = whitelistToApply.Ips
+
+	// Resyncs and provider changes reconcile every Service object, but most of them are already up to date
+	if sameSourceRanges(service, desired) {
+		glog.V(1).Infof("Source ranges of Service '%s/%s' are up to date, skipping the write", namespace, name)
+		skippedWrites.WithLabelValues(namespace).Inc()
+		return nil
+	}
+
+	// If this request fails, this item will be requeued, and the source ranges will be calculated again from the observed state
+	if _, err := serviceWhitelister.serviceRepository.Save(desired, ManagedWhitelistAnnotation); err != nil {
+		serviceWhitelister.recordSaveFailure(service, err)
+		return err
+	}
+	glog.V(0).Infof("Saved changes to Service resource '%s'", service.Name)
+	whitelister.recordWhitelistChange(service, provider, providers, previouslyManagedIps, managedIps)
+
+	return nil
+}
+
+// unmanage removes the source ranges managed by this controller from a Service object, because of the given reason.
+// Ranges that were added manually are kept, and the internal annotation is deleted.
+func (serviceWhitelister *ServiceWhitelister) unmanage(service *v1.Service, reason string) error {
+	managedIps := whitelist.NewWhitelistFromString(service.Annotations[ManagedWhitelistAnnotation])
+	currentWhitelistedIps := whitelist.NewWhitelistFromArray(service.Spec.LoadBalancerSourceRanges)
+	currentWhitelistedIps.RemoveCovered(managedIps)
+
+	desired := newDesiredService(service)
+	delete(desired.Annotations, ManagedWhitelistAnnotation)
+	desired.Spec.LoadBalancerSourceRanges = currentWhitelistedIps.Ips
+	if len(currentWhitelistedIps.Ips) == 0 {
+		desired.Spec.LoadBalancerSourceRanges = nil
+	}
+
+	if _, err := serviceWhitelister.serviceRepository.Save(desired, ManagedWhitelistAnnotation); err != nil {
+		serviceWhitelister.recordSaveFailure(service, err)
+		return err
+	}
+	glog.V(0).Infof("%s for Service '%s/%s'. Removed managed IPs: %s", reason, service.Namespace, service.Name, managedIps.ToString())
+	if len(managedIps.Ips) > 0 {
+		serviceWhitelister.whitelister.event(service, v1.EventTypeNormal, EventReasonWhitelistRemoved, "%s: removed %s", reason, managedIps.ToString())
+	}
+
+	return nil
+}
+
+// recordSaveFailure records a Warning Event for an error saving a Service object.
+// Conflicts are saved again right away, so they are only recorded by Whitelist when they keep happening.
+func (serviceWhitelister *ServiceWhitelister) recordSaveFailure(service *v1.Service, err error) {
+	if errors.IsConflict(err) {
+		return
+	}
+	serviceWhitelister.whitelister.event(service, v1.EventTypeWarning, EventReasonSaveFailed, "Error saving the source ranges: %s", err.Error())
+}
+
+// sameSourceRanges tells whether the source ranges and the managed annotation of both Service objects contain the same CIDRs,
+// no matter their order or whether bare IPs have a prefix length. Invalid CIDRs are always written away.
+func sameSourceRanges(current *v1.Service, desired *v1.Service) bool {
+	currentRanges := strings.Join(current.Spec.LoadBalancerSourceRanges, ",")
+	if len(whitelist.InvalidIPs(currentRanges)) > 0 {
+		return false
+	}
+	if whitelist.NewWhitelistFromString(currentRanges).ToString() != whitelist.NewWhitelistFromArray(desired.Spec.LoadBalancerSourceRanges).ToString() {
+		return false
+	}
+
+	currentValue, currentOk := current.Annotations[ManagedWhitelistAnnotation]
+	desiredValue, desiredOk := desired.Annotations[ManagedWhitelistAnnotation]
+	if currentOk != desiredOk || len(whitelist.InvalidIPs(currentValue)) > 0 {
+		return false
+	}
+	return whitelist.NewWhitelistFromString(currentValue).ToString() == whitelist.NewWhitelistFromString(desiredValue).ToString()
+}
+
+// newDesiredService returns a copy of the observed Service object with its own annotations, so the desired state can be set
+// without changing the observed state, which is shared with the informer cache
+func newDesiredService(observed *v1.Service) *v1.Service {
+	desired := *observed
+	desired.Annotations = make(map[string]string, len(observed.Annotations))
+	for name, value := range observed.Annotations {
+		desired.Annotations[name] = value
+	}
+	return &desired
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/repository"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestThatSourceRangesOfLoadBalancerServicesAreWhitelisted(t *testing.T) {
+	serviceRepository := repository.NewFakeServiceRepository()
+	serviceRepository.Save(BuildService("team", "api", v1.ServiceTypeLoadBalancer, map[string]string{DMZProvidersAnnotation: "vpn"}))
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4", "offices": "1.2.3.4/32"}))
+
+	err := NewServiceWhitelister(serviceRepository, configMapRepository).Whitelist("team/service:api")
+	service, _ := serviceRepository.Get("team", "api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"4.4.4.4/32"}, service.Spec.LoadBalancerSourceRanges)
+	assert.Equal("4.4.4.4/32", service.Annotations[ManagedWhitelistAnnotation])
+}
+
+func TestThatManualSourceRangesAreKept(t *testing.T) {
+	serviceRepository := repository.NewFakeServiceRepository()
+	service := BuildService("team", "api", v1.ServiceTypeLoadBalancer, map[string]string{DMZProvidersAnnotation: "vpn", ManagedWhitelistAnnotation: "4.4.4.4/32"})
+	service.Spec.LoadBalancerSourceRanges = []string{"4.4.4.4/32", "9.9.9.9/32"}
+	serviceRepository.Save(service)
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "5.5.5.5/32"}))
+
+	err := NewServiceWhitelister(serviceRepository, configMapRepository).Whitelist("team/service:api")
+	service, _ = serviceRepository.Get("team", "api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"5.5.5.5/32", "9.9.9.9/32"}, service.Spec.LoadBalancerSourceRanges, "Only the previously managed range should be replaced")
+	assert.Equal("5.5.5.5/32", service.Annotations[ManagedWhitelistAnnotation])
+}
+
+func TestThatManagedSourceRangesAreRemovedWhenProvidersAnnotationIsRemoved(t *testing.T) {
+	serviceRepository := repository.NewFakeServiceRepository()
+	service := BuildService("team", "api", v1.ServiceTypeLoadBalancer, map[string]string{ManagedWhitelistAnnotation: "4.4.4.4/32"})
+	service.Spec.LoadBalancerSourceRanges = []string{"4.4.4.4/32", "9.9.9.9/32"}
+	serviceRepository.Save(service)
+	recorder := record.NewFakeRecorder(10)
+
+	serviceWhitelister := NewServiceWhitelister(serviceRepository, NewConfigMapRepositoryByNamespace())
+	serviceWhitelister.whitelister.recorder = recorder
+	err := serviceWhitelister.Whitelist("team/service:api")
+	service, _ = serviceRepository.Get("team", "api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"9.9.9.9/32"}, service.Spec.LoadBalancerSourceRanges)
+	assert.NotContains(service.Annotations, ManagedWhitelistAnnotation, "The managed annotation should be removed")
+	assert.Equal("Normal WhitelistRemoved Providers annotation was removed: removed 4.4.4.4/32", <-recorder.Events)
+}
+
+func TestThatSourceRangesAreKeptWhenTheCentralConfigMapIsDeleted(t *testing.T) {
+	serviceRepository := repository.NewFakeServiceRepository()
+	service := BuildService("team", "api", v1.ServiceTypeLoadBalancer, map[string]string{DMZProvidersAnnotation: "vpn", ManagedWhitelistAnnotation: "4.4.4.4/32"})
+	service.Spec.LoadBalancerSourceRanges = []string{"4.4.4.4/32"}
+	serviceRepository.Save(service)
+
+	serviceWhitelister := NewServiceWhitelister(serviceRepository, NewConfigMapRepositoryByNamespace())
+	err := serviceWhitelister.Whitelist("team/service:api")
+	kept, _ := serviceRepository.Get("team", "api")
+
+	serviceWhitelister.whitelister.configMapDeletionPolicy = ConfigMapDeletionPolicyRemove
+	serviceWhitelister.Whitelist("team/service:api")
+	removed, _ := serviceRepository.Get("team", "api")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"4.4.4.4/32"}, kept.Spec.LoadBalancerSourceRanges, "The last known source ranges should be kept by default")
+	assert.Empty(removed.Spec.LoadBalancerSourceRanges, "The managed source ranges should be removed with the remove policy")
+	assert.Equal("vpn", removed.Annotations[DMZProvidersAnnotation], "The providers should be whitelisted again once the ConfigMap is back")
+}
+
+func TestThatOnlyLoadBalancerServicesGetSourceRanges(t *testing.T) {
+	serviceRepository := repository.NewFakeServiceRepository()
+	serviceRepository.Save(BuildService("team", "api", v1.ServiceTypeClusterIP, map[string]string{DMZProvidersAnnotation: "vpn"}))
+	configMapRepository := NewConfigMapRepositoryByNamespace()
+	configMapRepository.Save(BuildConfigMap("dmz", map[string]string{"vpn": "4.4.4.4/32"}))
+	recorder := record.NewFakeRecorder(10)
+
+	serviceWhitelister := NewServiceWhitelister(serviceRepository, configMapRepository)
+	serviceWhitelister.whitelister.recorder = recorder
+	err := serviceWhitelister.Whitelist("team/service:api")
+	service, _ := serviceRepository.Get("team", "api")
+
+	assert := assert.New(t)
+	assert.NoError(err, "Retrying won't change the Service type")
+	assert.Empty(service.Spec.LoadBalancerSourceRanges, "The API server rejects source ranges on other Service types")
+	assert.Empty(recorder.Events, "Nothing changes until the Service type does, so no Event is recorded on every resync")
+}
+
+func TestThatUpToDateSourceRangesAreNotWritten(t *testing.T) {
+	service := BuildService("team", "api", v1.ServiceTypeLoadBalancer, map[string]string{DMZProvidersAnnotation: "vpn", ManagedWhitelistAnnotation: "4.4.4.4/32"})
+	service.Spec.LoadBalancerSourceRanges = []string{"9.9.9.9/32", "4.4.4.4/32"}
+	desired := newDesiredService(service)
+	desired.Spec.LoadBalancerSourceRanges = []string{"4.4.4.4/32", "9.9.9.9/32"}
+
+	assert := assert.New(t)
+	assert.True(sameSourceRanges(service, desired), "The order of the source ranges doesn't matter")
+
+	desired.Annotations[ManagedWhitelistAnnotation] = "4.4.4.4/32,9.9.9.9/32"
+	assert.False(sameSourceRanges(service, desired), "The managed annotation must be written too")
+}
+
+func NewServiceWhitelister(serviceRepository repository.ServiceRepository, configMapRepository repository.ConfigMapRepository) *ServiceWhitelister {
+	whitelister := NewIngressWhitelister(repository.NewFakeIngressRepository(), configMapRepository)
+	whitelister.configNamespace = "dmz"
+	return &ServiceWhitelister{
+		whitelister:       whitelister,
+		serviceRepository: serviceRepository,
+	}
+}
+
+func BuildService(namespace string, name string, serviceType v1.ServiceType, annotations map[string]string) *v1.Service {
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Type: serviceType,
+		},
+	}
+	service.Name = name
+	service.Namespace = namespace
+	service.Annotations = annotations
+
+	return service
+}
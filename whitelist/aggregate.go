@@ -0,0 +1,96 @@
+package whitelist
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// Aggregate rewrites the Whitelist as the smallest equivalent list of CIDRs.
+// Every CIDR is normalised to its network address, CIDRs covered by a wider one are dropped,
+// and adjacent CIDRs are merged together.
+func (whitelist *Whitelist) Aggregate() {
+	ipv4 := aggregate(parseNetworks(whitelist.IPv4().Ips))
+	ipv6 := aggregate(parseNetworks(whitelist.IPv6().Ips))
+
+	whitelist.Ips = []string{}
+	for _, network := range append(ipv4, ipv6...) {
+		whitelist.Ips = append(whitelist.Ips, network.String())
+	}
+}
+
+// Normalise rewrites every CIDR of the Whitelist with its network address, like '10.0.0.0/8' instead of '10.0.0.1/8',
+// which is the only way some APIs accept them. Unlike Aggregate, CIDRs are neither dropped nor merged, unless they end up the same.
+func (whitelist *Whitelist) Normalise() {
+	result := []string{}
+	for _, network := range parseNetworks(whitelist.Ips) {
+		result = append(result, network.String())
+	}
+	whitelist.Ips = removeDuplicates(result)
+	sortIPs(whitelist.Ips)
+}
+
+// parseNetworks converts CIDRs that have been validated by validateIPs to networks, sorted by address and prefix length
+func parseNetworks(cidrs []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		if c := bytes.Compare(networks[i].IP, networks[j].IP); c != 0 {
+			return c < 0
+		}
+		onesI, _ := networks[i].Mask.Size()
+		onesJ, _ := networks[j].Mask.Size()
+		return onesI < onesJ
+	})
+
+	return networks
+}
+
+// aggregate merges sorted networks of the same address family
+func aggregate(networks []*net.IPNet) []*net.IPNet {
+	result := []*net.IPNet{}
+	for _, network := range networks {
+		if len(result) > 0 && covers(result[len(result)-1], network) {
+			continue
+		}
+		result = append(result, network)
+
+		// Merging two siblings may produce a network that is the sibling of the previous one
+		for len(result) > 1 && areSiblings(result[len(result)-2], result[len(result)-1]) {
+			result = append(result[:len(result)-2], parent(result[len(result)-2]))
+		}
+	}
+	return result
+}
+
+// covers tells whether the network a contains the whole network b
+func covers(a, b *net.IPNet) bool {
+	onesA, _ := a.Mask.Size()
+	onesB, _ := b.Mask.Size()
+	return onesA <= onesB && a.Contains(b.IP)
+}
+
+// areSiblings tells whether two different networks with the same prefix length share the same parent network
+func areSiblings(a, b *net.IPNet) bool {
+	onesA, _ := a.Mask.Size()
+	onesB, _ := b.Mask.Size()
+	if onesA != onesB || onesA == 0 || a.IP.Equal(b.IP) {
+		return false
+	}
+	return parent(a).IP.Equal(parent(b).IP)
+}
+
+// parent returns the network that is one bit shorter than the given network
+func parent(network *net.IPNet) *net.IPNet {
+	ones, bits := network.Mask.Size()
+	mask := net.CIDRMask(ones-1, bits)
+	return &net.IPNet{
+		IP:   network.IP.Mask(mask),
+		Mask: mask,
+	}
+}
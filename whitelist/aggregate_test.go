@@ -0,0 +1,49 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatAggregateNormalisesToNetworkAddress(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.1/24,2001:db8::1/32")
+	whitelist.Aggregate()
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/24", "2001:db8::/32"}, whitelist.Ips, "CIDRs must start at their network address")
+}
+
+func TestThatAggregateDropsCoveredRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/24,10.0.0.5/32,10.0.0.1/24,10.0.0.0/16")
+	whitelist.Aggregate()
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/16"}, whitelist.Ips, "Covered ranges must be dropped")
+}
+
+func TestThatAggregateMergesAdjacentRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/25,10.0.0.128/25,10.0.1.0/24,10.0.3.0/24")
+	whitelist.Aggregate()
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/23", "10.0.3.0/24"}, whitelist.Ips, "Adjacent ranges must be merged")
+}
+
+func TestThatAggregateDoesNotMergeRangesWithDifferentParents(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.1.0/24,10.0.2.0/24")
+	whitelist.Aggregate()
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.1.0/24", "10.0.2.0/24"}, whitelist.Ips, "Ranges that are not siblings can't be merged")
+}
+
+func TestThatAggregateKeepsAddressFamiliesApart(t *testing.T) {
+	whitelist := NewWhitelistFromString("0.0.0.0/1,128.0.0.0/1,::/1,8000::/1")
+	whitelist.Aggregate()
+	assert := assert.New(t)
+	assert.Equal([]string{"0.0.0.0/0", "::/0"}, whitelist.Ips, "Each address family must be aggregated separately")
+}
+
+func TestThatNormaliseOnlyRewritesTheNetworkAddress(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.1/8,10.0.0.0/8,10.0.0.5/32,2001:db8::1/32")
+	whitelist.Normalise()
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/8", "10.0.0.5/32", "2001:db8::/32"}, whitelist.Ips, "Covered ranges must be kept, and duplicates removed")
+}
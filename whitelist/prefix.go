@@ -0,0 +1,106 @@
+package whitelist
+
+import (
+	"net"
+)
+
+// prefixSet is a sorted list of disjoint networks, used to perform set operations over address space
+type prefixSet []*net.IPNet
+
+// newPrefixSet builds the smallest prefixSet covering the given CIDRs
+func newPrefixSet(cidrs []string) prefixSet {
+	whitelist := NewWhitelistFromArray(cidrs)
+	return append(
+		prefixSet(aggregate(parseNetworks(whitelist.IPv4().Ips))),
+		aggregate(parseNetworks(whitelist.IPv6().Ips))...,
+	)
+}
+
+// contains tells whether the given IP belongs to any network of the set
+func (set prefixSet) contains(ip net.IP) bool {
+	for _, network := range set {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps tells whether the given network shares any address with the set
+func (set prefixSet) overlaps(network *net.IPNet) bool {
+	for _, member := range set {
+		if covers(member, network) || covers(network, member) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtract returns the networks covering the addresses of the given network that are not in the set
+func (set prefixSet) subtract(network *net.IPNet) []*net.IPNet {
+	remaining := []*net.IPNet{network}
+	for _, member := range set {
+		next := []*net.IPNet{}
+		for _, candidate := range remaining {
+			next = append(next, subtract(candidate, member)...)
+		}
+		remaining = next
+	}
+	return remaining
+}
+
+// intersect returns the networks covering the addresses of the given network that are also in the set
+func (set prefixSet) intersect(network *net.IPNet) []*net.IPNet {
+	result := []*net.IPNet{}
+	for _, member := range set {
+		if covers(member, network) {
+			return []*net.IPNet{network}
+		}
+		if covers(network, member) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// subtract returns the networks covering the addresses of a that are not in b
+func subtract(a, b *net.IPNet) []*net.IPNet {
+	if covers(b, a) {
+		return []*net.IPNet{}
+	}
+	if !covers(a, b) {
+		return []*net.IPNet{a}
+	}
+
+	// Split a in halves until reaching b, keeping every half that doesn't contain b
+	result := []*net.IPNet{}
+	current := a
+	for !sameNetwork(current, b) {
+		low, high := split(current)
+		if covers(low, b) {
+			result = append(result, high)
+			current = low
+		} else {
+			result = append(result, low)
+			current = high
+		}
+	}
+	return result
+}
+
+// split returns the two halves of the given network
+func split(network *net.IPNet) (*net.IPNet, *net.IPNet) {
+	ones, bits := network.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	low := network.IP.Mask(mask)
+	high := make(net.IP, len(low))
+	copy(high, low)
+	high[ones/8] |= 0x80 >> uint(ones%8)
+
+	return &net.IPNet{IP: low, Mask: mask}, &net.IPNet{IP: high, Mask: mask}
+}
+
+// sameNetwork tells whether both networks have the same address and prefix length
+func sameNetwork(a, b *net.IPNet) bool {
+	return covers(a, b) && covers(b, a)
+}
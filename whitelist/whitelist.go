@@ -1,7 +1,10 @@
 package whitelist
 
 import (
+	"bytes"
+	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
@@ -46,40 +49,216 @@ func (whitelist *Whitelist) Merge(anotherWhitelist *Whitelist) {
 // Add adds ips to the current Whitelist
 func (whitelist *Whitelist) Add(sourceWhitelist []string) {
 	whitelist.Ips = removeDuplicates(append(whitelist.Ips, validateIPs(sourceWhitelist)...))
+	sortIPs(whitelist.Ips)
 }
 
-// Minus removes IP's from given Whitelist from current Whitelist
+// Minus removes the addresses of the given Whitelist from the current Whitelist.
+// CIDRs that are partially covered by the subtracted Whitelist are split, keeping only the addresses that are not subtracted.
 func (whitelist *Whitelist) Minus(substractedWhitelist *Whitelist) {
-	for i := len(substractedWhitelist.Ips) - 1; i >= 0; i-- {
-		index := findValue(whitelist.Ips, substractedWhitelist.Ips[i])
+	substracted := newPrefixSet(substractedWhitelist.Ips)
+
+	result := []string{}
+	for _, cidr := range whitelist.Ips {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || !substracted.overlaps(network) {
+			result = append(result, cidr)
+			continue
+		}
+		for _, remaining := range aggregate(substracted.subtract(network)) {
+			result = append(result, remaining.String())
+		}
+	}
+
+	whitelist.Ips = removeDuplicates(result)
+	sortIPs(whitelist.Ips)
+}
+
+// Intersect keeps only the addresses of the current Whitelist that are also in the given Whitelist
+func (whitelist *Whitelist) Intersect(anotherWhitelist *Whitelist) {
+	other := newPrefixSet(anotherWhitelist.Ips)
+
+	result := []string{}
+	for _, cidr := range whitelist.Ips {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, shared := range other.intersect(network) {
+			result = append(result, shared.String())
+		}
+	}
+
+	whitelist.Ips = removeDuplicates(result)
+	sortIPs(whitelist.Ips)
+}
+
+// Remove removes the exact CIDRs of the given Whitelist from the current Whitelist, leaving overlapping CIDRs untouched
+func (whitelist *Whitelist) Remove(removedWhitelist *Whitelist) {
+	for i := len(removedWhitelist.Ips) - 1; i >= 0; i-- {
+		index := findValue(whitelist.Ips, removedWhitelist.Ips[i])
 		if index > -1 {
 			whitelist.Ips = append(whitelist.Ips[:index], whitelist.Ips[index+1:]...)
 		}
 	}
 }
 
+// RemoveCovered removes the CIDRs of the current Whitelist whose addresses are all in the given Whitelist, no matter how both of them are written.
+// Unlike Minus, CIDRs that are only partially covered are kept untouched instead of being split, so wider ranges overlapping the given Whitelist survive.
+func (whitelist *Whitelist) RemoveCovered(removedWhitelist *Whitelist) {
+	removed := newPrefixSet(removedWhitelist.Ips)
+
+	result := []string{}
+	for _, cidr := range whitelist.Ips {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil || len(removed.subtract(network)) > 0 {
+			result = append(result, cidr)
+		}
+	}
+	whitelist.Ips = result
+}
+
+// Contains tells whether the given IP address is whitelisted
+func (whitelist *Whitelist) Contains(ip string) bool {
+	parsedIP := net.ParseIP(strings.TrimSpace(ip))
+	if parsedIP == nil {
+		return false
+	}
+	return newPrefixSet(whitelist.Ips).contains(parsedIP)
+}
+
+// Overlaps tells whether the current Whitelist shares any address with the given Whitelist
+func (whitelist *Whitelist) Overlaps(anotherWhitelist *Whitelist) bool {
+	other := newPrefixSet(anotherWhitelist.Ips)
+	for _, network := range newPrefixSet(whitelist.Ips) {
+		if other.overlaps(network) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPv4 returns a new Whitelist with only the IPv4 addresses of the current Whitelist
+func (whitelist *Whitelist) IPv4() *Whitelist {
+	return whitelist.filter(func(ip net.IP) bool { return ip.To4() != nil })
+}
+
+// IPv6 returns a new Whitelist with only the IPv6 addresses of the current Whitelist
+func (whitelist *Whitelist) IPv6() *Whitelist {
+	return whitelist.filter(func(ip net.IP) bool { return ip.To4() == nil })
+}
+
+// filter returns a new Whitelist with the addresses of the current Whitelist that match the given function
+func (whitelist *Whitelist) filter(matches func(ip net.IP) bool) *Whitelist {
+	filtered := NewEmptyWhitelist()
+	for _, cidr := range whitelist.Ips {
+		if ip, _, err := net.ParseCIDR(cidr); err == nil && matches(ip) {
+			filtered.Ips = append(filtered.Ips, cidr)
+		}
+	}
+	return filtered
+}
+
 // ToString converts the Whitelist to a string
 func (whitelist *Whitelist) ToString() string {
 	return strings.Join(whitelist.Ips, ",")
 }
 
-// validateIPs makes sure all the IP's that we want to add are valid IPs or valid CIDR
+// InvalidIPs returns the addresses of a comma separated list that are neither valid IPs nor valid CIDRs
+func InvalidIPs(ipsAsString string) []string {
+	invalid := []string{}
+	for _, address := range strings.Split(ipsAsString, ",") {
+		if strings.TrimSpace(address) == "" {
+			continue
+		}
+		if _, err := toCIDR(address); err != nil {
+			invalid = append(invalid, strings.TrimSpace(address))
+		}
+	}
+	return invalid
+}
+
+// validateIPs makes sure all the IP's that we want to add are valid IPs or valid CIDR, returning them in CIDR notation
 func validateIPs(sourceWhitelist []string) []string {
-	for i := len(sourceWhitelist) - 1; i >= 0; i-- {
-		if sourceWhitelist[i] != "" {
-			_, _, err := net.ParseCIDR(sourceWhitelist[i])
-			if err != nil {
-				sourceWhitelist[i] += "/32"
-				_, _, err = net.ParseCIDR(sourceWhitelist[i])
-			}
+	result := []string{}
+	for _, address := range sourceWhitelist {
+		if strings.TrimSpace(address) == "" {
+			continue
+		}
+
+		cidr, err := toCIDR(address)
+		if err != nil {
+			glog.Warningf("The IP '%s' won't be added to the whitelist: %s", address, err)
+			continue
+		}
+		result = append(result, cidr)
+	}
+	return result
+}
+
+// toCIDR converts an IP or CIDR, of any address family, to its CIDR notation.
+// Bare IPv4 addresses become a /32 and bare IPv6 addresses become a /128.
+func toCIDR(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if !strings.Contains(address, "/") {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return "", fmt.Errorf("invalid IP address: %s", address)
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(address)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := ipNet.Mask.Size()
+	if ip.To4() != nil && bits == net.IPv6len*8 {
+		// IPv4-mapped IPv6 ranges are written as plain IPv4 ranges, which they can only be when they don't go beyond the mapped prefix
+		if ones < 96 {
+			return "", fmt.Errorf("invalid IPv4-mapped CIDR, its prefix must be at least /96: %s", address)
+		}
+		ones -= 96
+	}
+	return fmt.Sprintf("%s/%d", ip.String(), ones), nil
+}
+
+// sortIPs orders CIDRs deterministically: IPv4 ranges first, then IPv6 ranges, each of them by address and prefix length
+func sortIPs(cidrs []string) {
+	sort.SliceStable(cidrs, func(i, j int) bool {
+		return compareCIDRs(cidrs[i], cidrs[j]) < 0
+	})
+}
 
-			if err != nil {
-				glog.Warningf("The IP '%s' won't be added to the whitelist: %s", sourceWhitelist[i], err)
-				sourceWhitelist = append(sourceWhitelist[:i], sourceWhitelist[i+1:]...)
-			}
+// compareCIDRs compares two CIDRs that have been validated by validateIPs.
+// Anything that isn't a CIDR is ordered after every CIDR, by its text.
+func compareCIDRs(a, b string) int {
+	ipA, netA, errA := net.ParseCIDR(a)
+	ipB, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		if errA == nil {
+			return -1
 		}
+		if errB == nil {
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+	v4A, v4B := ipA.To4() != nil, ipB.To4() != nil
+	if v4A != v4B {
+		if v4A {
+			return -1
+		}
+		return 1
+	}
+	if c := bytes.Compare(ipA.To16(), ipB.To16()); c != 0 {
+		return c
 	}
-	return sourceWhitelist
+	onesA, _ := netA.Mask.Size()
+	onesB, _ := netB.Mask.Size()
+	return onesA - onesB
 }
 
 // removeDuplicates removes duplicate elements from array
@@ -88,3 +88,120 @@ func TestThatTransformWhitelistToString(t *testing.T) {
 	assert := assert.New(t)
 	assert.Equal("1.2.3.4/32,4.4.4.4/32,8.8.8.8/32", whitelist.ToString(), "String representation is wrong")
 }
+
+func TestThatBareIPv6AddressesBecomeSingleHostRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("2001:db8::1")
+	assert := assert.New(t)
+	assert.Equal([]string{"2001:db8::1/128"}, whitelist.Ips, "IPv6 addresses must be whitelisted as /128")
+}
+
+func TestThatIPv6RangesAreAccepted(t *testing.T) {
+	whitelist := NewWhitelistFromString("2001:DB8::/32, 2001:db8:0:0::/32")
+	assert := assert.New(t)
+	assert.Equal([]string{"2001:db8::/32"}, whitelist.Ips, "IPv6 ranges must be written in canonical form without duplicates")
+}
+
+func TestThatMixedFamiliesAreSortedDeterministically(t *testing.T) {
+	whitelist := NewWhitelistFromString("2001:db8::1,8.8.8.8,fe80::/10,1.2.3.0/24")
+	assert := assert.New(t)
+	assert.Equal("1.2.3.0/24,8.8.8.8/32,2001:db8::1/128,fe80::/10", whitelist.ToString(), "IPv4 ranges must come before IPv6 ranges")
+}
+
+func TestThatItIgnoresAnInvalidIPv6Address(t *testing.T) {
+	whitelist := NewWhitelistFromString("2001:db8::1")
+	whitelist.Add([]string{"2001:db8:::2", "2001:db8::/129"})
+	assert := assert.New(t)
+	assert.Len(whitelist.Ips, 1, "It must ignore non valid IPv6 addresses")
+}
+
+func TestThatWhitelistCanBeSplitByAddressFamily(t *testing.T) {
+	whitelist := NewWhitelistFromString("2001:db8::1,8.8.8.8")
+	assert := assert.New(t)
+	assert.Equal([]string{"8.8.8.8/32"}, whitelist.IPv4().Ips, "Only IPv4 ranges expected")
+	assert.Equal([]string{"2001:db8::1/128"}, whitelist.IPv6().Ips, "Only IPv6 ranges expected")
+}
+
+func TestThatMinusSplitsPartiallySubtractedRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/22,8.8.8.8")
+	whitelist.Minus(NewWhitelistFromString("10.0.1.0/24"))
+	assert := assert.New(t)
+	assert.Equal([]string{"8.8.8.8/32", "10.0.0.0/24", "10.0.2.0/23"}, whitelist.Ips, "Only the subtracted range must be removed")
+}
+
+func TestThatMinusRemovesRangesCoveredByAWiderRange(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.5.0/24,8.8.8.8")
+	whitelist.Minus(NewWhitelistFromString("10.0.0.0/16"))
+	assert := assert.New(t)
+	assert.Equal([]string{"8.8.8.8/32"}, whitelist.Ips, "Ranges inside the subtracted range must be removed")
+}
+
+func TestThatMinusIgnoresWhitespace(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/24 ,8.8.8.8")
+	whitelist.Minus(NewWhitelistFromString(" 10.0.0.0/24"))
+	assert := assert.New(t)
+	assert.Equal([]string{"8.8.8.8/32"}, whitelist.Ips, "Whitespace must not prevent the subtraction")
+}
+
+func TestThatRemoveOnlyRemovesExactRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/16,10.0.5.0/24")
+	whitelist.Remove(NewWhitelistFromString("10.0.5.0/24"))
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/16"}, whitelist.Ips, "Overlapping ranges must be kept")
+}
+
+func TestThatRemoveCoveredRemovesRangesWrittenDifferently(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/24,10.0.1.0/24,10.0.0.1/23,8.8.8.8")
+	whitelist.RemoveCovered(NewWhitelistFromString("10.0.0.0/23"))
+	assert := assert.New(t)
+	assert.Equal([]string{"8.8.8.8/32"}, whitelist.Ips, "Ranges inside the removed range must be removed, even when aggregated or not normalised")
+}
+
+func TestThatRemoveCoveredKeepsPartiallyCoveredRanges(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/16,10.0.5.0/24")
+	whitelist.RemoveCovered(NewWhitelistFromString("10.0.5.0/24"))
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.0/16"}, whitelist.Ips, "Wider ranges must be kept untouched")
+}
+
+func TestThatIntersectKeepsOnlySharedAddresses(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/16,8.8.8.8,2001:db8::/32")
+	whitelist.Intersect(NewWhitelistFromString("10.0.5.0/24,10.1.0.0/16,2001:db8:1::/48"))
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.5.0/24", "2001:db8:1::/48"}, whitelist.Ips, "Only shared addresses must be kept")
+}
+
+func TestThatContainsChecksAddressSpace(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/16,2001:db8::/32")
+	assert := assert.New(t)
+	assert.True(whitelist.Contains("10.0.200.1"), "Address inside a range must be contained")
+	assert.True(whitelist.Contains("2001:db8::42"), "IPv6 address inside a range must be contained")
+	assert.False(whitelist.Contains("10.1.0.1"), "Address outside the ranges must not be contained")
+	assert.False(whitelist.Contains("not-an-ip"), "Invalid addresses are never contained")
+}
+
+func TestThatOverlapsDetectsSharedAddresses(t *testing.T) {
+	whitelist := NewWhitelistFromString("10.0.0.0/16")
+	assert := assert.New(t)
+	assert.True(whitelist.Overlaps(NewWhitelistFromString("10.0.5.5,1.1.1.1")), "Whitelists share 10.0.5.5")
+	assert.True(whitelist.Overlaps(NewWhitelistFromString("10.0.0.0/8")), "Whitelists share 10.0.0.0/16")
+	assert.False(whitelist.Overlaps(NewWhitelistFromString("10.1.0.0/16,::/0")), "Whitelists don't share any address")
+}
+
+func TestThatInvalidIPsAreReported(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"5.5.5", "2001:db8::/129"}, InvalidIPs("1.2.3.4, 5.5.5,,2001:db8::/129,10.0.0.0/8"), "Invalid addresses must be reported")
+	assert.Empty(InvalidIPs("1.2.3.4,2001:db8::1"), "Valid addresses must not be reported")
+}
+
+func TestThatIPv4MappedRangesBeyondTheMappedPrefixAreRejected(t *testing.T) {
+	whitelist := NewWhitelistFromString("::ffff:1.2.3.0/120,::ffff:1.2.3.4/80")
+	assert := assert.New(t)
+	assert.Equal([]string{"1.2.3.0/24"}, whitelist.Ips, "Mapped ranges can only be written as IPv4 ranges from a /96 prefix")
+	assert.Equal([]string{"::ffff:1.2.3.4/80"}, InvalidIPs("::ffff:1.2.3.4/80"))
+}
+
+func TestThatSortingDoesNotPanicOnInvalidRanges(t *testing.T) {
+	cidrs := []string{"1.2.3.4/-16", "8.8.8.8/32", "1.2.3.0/24"}
+	sortIPs(cidrs)
+	assert.Equal(t, []string{"1.2.3.0/24", "8.8.8.8/32", "1.2.3.4/-16"}, cidrs, "Invalid ranges must be ordered last")
+}
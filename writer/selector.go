@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// Legacy writes the annotation read by the first ingress controllers, and by Ingress objects without a known class
+	Legacy = &AnnotationWriter{Name: "ingress.kubernetes.io/whitelist-source-range", Separator: ","}
+
+	// Nginx writes the annotation read by ingress-nginx
+	Nginx = &AnnotationWriter{Name: "nginx.ingress.kubernetes.io/whitelist-source-range", Separator: ","}
+
+	// Traefik writes the annotation read by Traefik
+	Traefik = &AnnotationWriter{Name: "traefik.ingress.kubernetes.io/whitelist-source-range", Separator: ", "}
+
+	// HAProxy writes the annotation read by the HAProxy ingress controller, which prefers bare IPs for single hosts
+	HAProxy = &AnnotationWriter{Name: "haproxy.org/whitelist", Separator: ", ", BareHosts: true}
+
+	// Flavours are the built-in writers, by name
+	Flavours = map[string]Writer{
+		"legacy":  Legacy,
+		"nginx":   Nginx,
+		"traefik": Traefik,
+		"haproxy": HAProxy,
+	}
+)
+
+// Selector chooses the Writer of an Ingress object by its ingress class
+type Selector struct {
+	byClass  map[string]Writer
+	fallback Writer
+}
+
+// NewSelector returns a Selector choosing the given Writer for every ingress class, until other writers are registered
+func NewSelector(fallback Writer) *Selector {
+	return &Selector{
+		byClass:  make(map[string]Writer),
+		fallback: fallback,
+	}
+}
+
+// Register chooses the given Writer for the Ingress objects of an ingress class
+func (selector *Selector) Register(class string, writer Writer) {
+	selector.byClass[class] = writer
+}
+
+// For returns the Writer of the Ingress objects of an ingress class. The class is empty for Ingress objects without one.
+func (selector *Selector) For(class string) Writer {
+	if writer, ok := selector.byClass[class]; ok {
+		return writer
+	}
+	return selector.fallback
+}
+
+// ParseSelector returns a Selector from the name of the fallback flavour and a comma separated list of 'class=flavour' pairs
+func ParseSelector(fallback string, classes string) (*Selector, error) {
+	fallbackWriter, err := getFlavour(fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := NewSelector(fallbackWriter)
+	for _, pair := range strings.Split(classes, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid writer '%s', it must look like 'class=flavour'", pair)
+		}
+		writer, err := getFlavour(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		selector.Register(strings.TrimSpace(parts[0]), writer)
+	}
+	return selector, nil
+}
+
+// getFlavour returns the built-in Writer with the given name
+func getFlavour(name string) (Writer, error) {
+	writer, ok := Flavours[strings.TrimSpace(name)]
+	if !ok {
+		names := make([]string, 0, len(Flavours))
+		for name := range Flavours {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown writer flavour '%s', it must be one of: %s", strings.TrimSpace(name), strings.Join(names, ", "))
+	}
+	return writer, nil
+}
+
+// ForAnnotation returns the built-in Writer of the given annotation, and false when no built-in Writer writes it
+func ForAnnotation(annotation string) (Writer, bool) {
+	for _, writer := range Flavours {
+		if writer.Annotation() == annotation {
+			return writer, true
+		}
+	}
+	return nil, false
+}
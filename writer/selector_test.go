@@ -0,0 +1,49 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatWritersAreChosenByIngressClass(t *testing.T) {
+	selector, err := ParseSelector("legacy", "nginx=nginx, public=traefik,internal=haproxy")
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(Nginx, selector.For("nginx"))
+	assert.Equal(Traefik, selector.For("public"))
+	assert.Equal(HAProxy, selector.For("internal"))
+	assert.Equal(Legacy, selector.For("unknown"), "Classes without a writer use the fallback one")
+	assert.Equal(Legacy, selector.For(""), "Ingress objects without a class use the fallback one")
+}
+
+func TestThatWritersCanBeRegistered(t *testing.T) {
+	custom := &AnnotationWriter{Name: "example.com/allowed-sources", Separator: " "}
+	selector := NewSelector(Nginx)
+	selector.Register("custom", custom)
+
+	assert := assert.New(t)
+	assert.Equal(custom, selector.For("custom"))
+	assert.Equal(Nginx, selector.For("nginx"))
+}
+
+func TestThatUnknownFlavoursAreRejected(t *testing.T) {
+	_, err := ParseSelector("legacy", "nginx=envoy")
+	assert.EqualError(t, err, "Unknown writer flavour 'envoy', it must be one of: haproxy, legacy, nginx, traefik")
+
+	_, err = ParseSelector("envoy", "")
+	assert.Error(t, err)
+
+	_, err = ParseSelector("legacy", "nginx")
+	assert.Error(t, err)
+}
+
+func TestThatWritersAreFoundByAnnotation(t *testing.T) {
+	writer, ok := ForAnnotation("nginx.ingress.kubernetes.io/whitelist-source-range")
+	assert.True(t, ok)
+	assert.Equal(t, Nginx, writer)
+
+	_, ok = ForAnnotation("example.com/allowed-sources")
+	assert.False(t, ok)
+}
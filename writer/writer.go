@@ -0,0 +1,80 @@
+package writer
+
+import (
+	"net"
+	"strings"
+	"unicode"
+
+	"github.com/fiunchinho/dmz-controller/whitelist"
+)
+
+// Writer stores the whitelist of an Ingress object in the annotation read by a flavour of ingress controller
+type Writer interface {
+	// Annotation returns the name of the annotation holding the whitelist
+	Annotation() string
+	// Split returns the addresses in a value of the annotation
+	Split(value string) []string
+	// Join returns the value of the annotation whitelisting the given CIDRs
+	Join(cidrs []string) string
+}
+
+// AnnotationWriter is a Writer storing the whitelist as a list of addresses in a single annotation
+type AnnotationWriter struct {
+	// Name is the name of the annotation
+	Name string
+	// Separator is written between addresses. Commas and whitespace are accepted as separators when reading.
+	Separator string
+	// BareHosts writes single host ranges, like /32 and /128, as bare IPs
+	BareHosts bool
+}
+
+// Annotation returns the name of the annotation holding the whitelist
+func (writer *AnnotationWriter) Annotation() string {
+	return writer.Name
+}
+
+// Split returns the addresses in a value of the annotation
+func (writer *AnnotationWriter) Split(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// Join returns the value of the annotation whitelisting the given CIDRs
+func (writer *AnnotationWriter) Join(cidrs []string) string {
+	addresses := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		addresses = append(addresses, writer.format(cidr))
+	}
+	return strings.Join(addresses, writer.Separator)
+}
+
+// format returns the address written for a CIDR
+func (writer *AnnotationWriter) format(cidr string) string {
+	if !writer.BareHosts {
+		return cidr
+	}
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return ip.String()
+	}
+	return cidr
+}
+
+// Read returns the whitelist stored in the given annotations by a Writer
+func Read(writer Writer, annotations map[string]string) *whitelist.Whitelist {
+	return whitelist.NewWhitelistFromArray(writer.Split(annotations[writer.Annotation()]))
+}
+
+// Write stores the given whitelist in the annotations by a Writer
+func Write(writer Writer, annotations map[string]string, ips *whitelist.Whitelist) {
+	annotations[writer.Annotation()] = writer.Join(ips.Ips)
+}
+
+// InvalidIPs returns the addresses stored in the given annotations by a Writer that are neither valid IPs nor valid CIDRs
+func InvalidIPs(writer Writer, annotations map[string]string) []string {
+	return whitelist.InvalidIPs(strings.Join(writer.Split(annotations[writer.Annotation()]), ","))
+}
@@ -0,0 +1,43 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/fiunchinho/dmz-controller/whitelist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatAddressesAreReadNoMatterTheSeparator(t *testing.T) {
+	annotations := map[string]string{Traefik.Annotation(): "1.2.3.4/32, 4.4.4.0/24,5.5.5.5 6.6.6.6/32"}
+
+	ips := Read(Traefik, annotations)
+
+	assert.Equal(t, []string{"1.2.3.4/32", "4.4.4.0/24", "5.5.5.5/32", "6.6.6.6/32"}, ips.Ips)
+}
+
+func TestThatEachWriterUsesItsOwnAnnotationAndSeparator(t *testing.T) {
+	ips := whitelist.NewWhitelistFromString("1.2.3.4/32,4.4.4.0/24")
+	annotations := map[string]string{}
+
+	Write(Nginx, annotations, ips)
+	Write(Traefik, annotations, ips)
+
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4/32,4.4.4.0/24", annotations["nginx.ingress.kubernetes.io/whitelist-source-range"])
+	assert.Equal("1.2.3.4/32, 4.4.4.0/24", annotations["traefik.ingress.kubernetes.io/whitelist-source-range"])
+}
+
+func TestThatSingleHostsCanBeWrittenAsBareIps(t *testing.T) {
+	ips := whitelist.NewWhitelistFromString("1.2.3.4/32,4.4.4.0/24,2001:db8::1/128")
+	annotations := map[string]string{}
+
+	Write(HAProxy, annotations, ips)
+
+	assert.Equal(t, "1.2.3.4, 4.4.4.0/24, 2001:db8::1", annotations["haproxy.org/whitelist"])
+}
+
+func TestThatInvalidAddressesAreReported(t *testing.T) {
+	annotations := map[string]string{HAProxy.Annotation(): "1.2.3.4, not-an-ip 4.4.4.0/24"}
+
+	assert.Equal(t, []string{"not-an-ip"}, InvalidIPs(HAProxy, annotations))
+}